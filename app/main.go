@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -23,10 +24,23 @@ func main() {
 		return
 	}
 
-	autokey.Init()
+	err = autokey.Init()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 	defer autokey.Teardown()
+	autokey.HandleErrors(func(err error) {
+		fmt.Println(err)
+	})
 	fmt.Println("Installed, press enter to exit")
-	expr.Eval()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = autokey.Eval(ctx, expr)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 	// time.Sleep(10 * time.Second)
 	fmt.Scanln()
 }
@@ -1,49 +1,210 @@
 package autokey
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"gopkg.in/yaml.v2"
+	"github.com/Sinacam/autokey/sys"
+	"gopkg.in/yaml.v3"
 )
 
-// ymlErrorString formats yml as an error string.
-func ymlErrorString(yml interface{}) string {
+// ymlError reports yml as unrecognized.
+func ymlError(yml interface{}) error {
 	str, err := yaml.Marshal(yml)
 	if err != nil {
-		return "bad yml argument"
+		return errors.New("bad yml argument")
 	}
-	return fmt.Sprint("unrecognized yaml element ", string(str))
+	return fmt.Errorf("unrecognized yaml element %v", strings.TrimSpace(string(str)))
 }
 
-// addErrorTrace adds a trace string to err.
-func addErrorTrace(err string, from interface{}) string {
-	return fmt.Sprintf("%v\n\tfrom %v", err, from)
+// compiler holds what is shared while compiling one source.
+// The compile methods record errors in errs and keep going, so that a
+// single pass finds every error. They return a nil Expr if the node or
+// anything under it has errors.
+type compiler struct {
+	e    *Engine  // engine the compiled expressions run on
+	file string   // name of the source file, empty if not from a file
+	path []string // keys and indices leading to the node being compiled
+	errs CompileErrors
+}
+
+// fail records err at node n under key.
+// Nodes not parsed from text, such as those encoded from values,
+// have no position.
+func (c *compiler) fail(n *yaml.Node, key string, err error) {
+	c.errs = append(c.errs, &CompileError{
+		File:   c.file,
+		Line:   n.Line,
+		Column: n.Column,
+		Path:   append([]string(nil), c.path...),
+		Key:    key,
+		Err:    err,
+	})
+}
+
+func (c *compiler) push(key string) {
+	c.path = append(c.path, key)
+}
+
+func (c *compiler) pop() {
+	c.path = c.path[:len(c.path)-1]
+}
+
+// compileSource decodes and compiles the YAML file at path for e.
+// Errors are reported under the keys in parent.
+func compileSource(e *Engine, path string, parent []string) (Expr, error) {
+	c := &compiler{e: e, file: path, path: append([]string(nil), parent...)}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var m yaml.Node
+	err = yaml.NewDecoder(f).Decode(&m)
+	if err != nil {
+		c.fail(&m, "", err)
+		return nil, c.errs
+	}
+
+	expr := c.compile(&m)
+	if len(c.errs) > 0 {
+		return nil, c.errs
+	}
+	return expr, nil
 }
 
 type Expr interface {
-	Eval() interface{} // Evaluate the expression value and its side-effect
-	Static() bool      // Returns true if the expression value is known statically and has no side-effect
+	Eval(ctx context.Context) interface{} // Evaluate the expression value and its side-effect until ctx is done
+	Static() bool                         // Returns true if the expression value is known statically and has no side-effect
 }
 
-// Compiles yml as a Expr recursively.
-// Errors in the structure of yml is reported as an error.
-// Errors in values causes a panic during execution of Expr instead.
-func Compile(yml interface{}) (Expr, error) {
-	fn, err := compile(yml)
-	if err != "" {
-		return nil, errors.New(err)
+// evalError aborts the evaluation in progress with a RuntimeError.
+// Expr.Eval has no error result, so the error unwinds to the nearest run.
+func evalError(key string, val interface{}, err error) {
+	panic(&RuntimeError{Key: key, Value: val, Err: err})
+}
+
+// run evaluates expr and recovers a RuntimeError aborting it.
+// Keys held down by an evaluation that failed or was cancelled
+// are released, including those of the runs it started, but not
+// those of other runs.
+func (e *Engine) run(ctx context.Context, expr Expr) (err error) {
+	held := newHeldSet(heldFrom(ctx))
+	ctx = context.WithValue(ctx, heldKey{}, held)
+	defer func() {
+		r := recover()
+		if r == nil {
+			if ctx.Err() != nil {
+				e.release(held)
+			}
+			return
+		}
+		re, ok := r.(*RuntimeError)
+		if !ok {
+			panic(r)
+		}
+		e.release(held)
+		err = re
+	}()
+
+	expr.Eval(ctx)
+	return nil
+}
+
+// Eval evaluates expr like expr.Eval, but returns a RuntimeError
+// instead of aborting the program. Actions started in the background,
+// such as by do, report their errors to the handler set by HandleErrors.
+// Cancelling ctx or tearing e down stops everything started by expr
+// and releases the keys it held down.
+// expr must have been compiled by e.
+func (e *Engine) Eval(ctx context.Context, expr Expr) error {
+	root := e.rootContext()
+	if root == nil {
+		return ErrNotInitialized
+	}
+
+	held := newHeldSet(nil)
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, heldKey{}, held))
+	go func() {
+		select {
+		case <-root.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+		e.release(held)
+	}()
+
+	return e.run(ctx, expr)
+}
+
+// Compile compiles yml as a Expr recursively, which runs on e.
+// yml is either a *yaml.Node, which keeps the order of mappings,
+// or a value that is encoded to one, with mapping keys sorted.
+// Errors in the structure of yml are all reported as CompileErrors.
+// Errors in values are RuntimeErrors reported during execution instead.
+func (e *Engine) Compile(yml interface{}) (Expr, error) {
+	n, ok := yml.(*yaml.Node)
+	if !ok {
+		n = &yaml.Node{}
+		err := n.Encode(yml)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c := &compiler{e: e}
+	fn := c.compile(n)
+	if len(c.errs) > 0 {
+		return nil, c.errs
 	}
 	return fn, nil
 }
 
+// Eval is Engine.Eval on the default engine.
+func Eval(ctx context.Context, expr Expr) error {
+	return defaultEngine.Eval(ctx, expr)
+}
+
+// Compile is Engine.Compile on the default engine.
+func Compile(yml interface{}) (Expr, error) {
+	return defaultEngine.Compile(yml)
+}
+
+// resolveNode skips over document and alias nodes.
+func resolveNode(n *yaml.Node) *yaml.Node {
+	for {
+		switch {
+		case n.Kind == yaml.DocumentNode && len(n.Content) > 0:
+			n = n.Content[0]
+		case n.Kind == yaml.AliasNode:
+			n = n.Alias
+		default:
+			return n
+		}
+	}
+}
+
+// keyString returns the mapping key k as a string.
+func keyString(k *yaml.Node) (string, bool) {
+	k = resolveNode(k)
+	if k.Kind != yaml.ScalarNode || k.ShortTag() != "!!str" {
+		return "", false
+	}
+	return k.Value, true
+}
+
 type boolExpr bool
 
-func (be boolExpr) Eval() interface{} {
+func (be boolExpr) Eval(context.Context) interface{} {
 	return bool(be)
 }
 
@@ -53,7 +214,7 @@ func (be boolExpr) Static() bool {
 
 type intExpr int
 
-func (ie intExpr) Eval() interface{} {
+func (ie intExpr) Eval(context.Context) interface{} {
 	return int(ie)
 }
 
@@ -63,7 +224,7 @@ func (ie intExpr) Static() bool {
 
 type floatExpr float64
 
-func (fe floatExpr) Eval() interface{} {
+func (fe floatExpr) Eval(context.Context) interface{} {
 	return float64(fe)
 }
 
@@ -73,7 +234,7 @@ func (fe floatExpr) Static() bool {
 
 type stringExpr string
 
-func (se stringExpr) Eval() interface{} {
+func (se stringExpr) Eval(context.Context) interface{} {
 	return string(se)
 }
 
@@ -81,25 +242,34 @@ func (se stringExpr) Static() bool {
 	return true
 }
 
-// compile uses an error string because the error trace is built up
-// during recursion.
-// TODO: migrate to recursive errors
-func compile(yml interface{}) (Expr, string) {
-	switch yml := yml.(type) {
-	case bool:
-		return boolExpr(yml), ""
-	case int:
-		return intExpr(yml), ""
-	case float64:
-		return floatExpr(yml), ""
-	case string:
-		return stringExpr(yml), ""
-	case []interface{}:
-		return compileSlice(yml)
-	case map[interface{}]interface{}:
-		return compileMap(yml)
+func (c *compiler) compile(n *yaml.Node) Expr {
+	n = resolveNode(n)
+	switch n.Kind {
+	case yaml.ScalarNode:
+		var val interface{}
+		err := n.Decode(&val)
+		if err != nil {
+			c.fail(n, "", err)
+			return nil
+		}
+
+		switch val := val.(type) {
+		case bool:
+			return boolExpr(val)
+		case int:
+			return intExpr(val)
+		case float64:
+			return floatExpr(val)
+		case string:
+			return stringExpr(val)
+		}
+	case yaml.SequenceNode:
+		return c.compileSlice(n)
+	case yaml.MappingNode:
+		return c.compileMap(n)
 	}
-	return nil, ymlErrorString(yml)
+	c.fail(n, "", ymlError(n))
+	return nil
 }
 
 type sliceExpr struct {
@@ -117,7 +287,7 @@ func newSliceExpr(subs []Expr) *sliceExpr {
 	se := sliceExpr{static: static}
 	if static {
 		for _, v := range subs {
-			se.staticSubs = append(se.staticSubs, v.Eval())
+			se.staticSubs = append(se.staticSubs, v.Eval(context.Background()))
 		}
 	} else {
 		se.subs = subs
@@ -125,14 +295,17 @@ func newSliceExpr(subs []Expr) *sliceExpr {
 	return &se
 }
 
-func (se *sliceExpr) Eval() interface{} {
+func (se *sliceExpr) Eval(ctx context.Context) interface{} {
 	if se.Static() {
 		return se.staticSubs
 	}
 
 	var ret []interface{}
 	for _, v := range se.subs {
-		ret = append(ret, v.Eval())
+		if ctx.Err() != nil {
+			break
+		}
+		ret = append(ret, v.Eval(ctx))
 	}
 	return ret
 }
@@ -141,53 +314,265 @@ func (se *sliceExpr) Static() bool {
 	return se.static
 }
 
-func compileSlice(yml []interface{}) (Expr, string) {
+func (c *compiler) compileSlice(n *yaml.Node) Expr {
 	var subs []Expr
-	for i, v := range yml {
-		sub, err := compile(v)
-		if err != "" {
-			return nil, addErrorTrace(err, i)
-		}
+	ok := true
+	for i, v := range n.Content {
+		c.push(strconv.Itoa(i))
+		sub := c.compile(v)
+		c.pop()
+		ok = ok && sub != nil
 		subs = append(subs, sub)
 	}
 
-	return newSliceExpr(subs), ""
+	if !ok {
+		return nil
+	}
+	return newSliceExpr(subs)
 }
 
-func compileMap(yml map[interface{}]interface{}) (Expr, string) {
-	var subs []Expr
-	for k, v := range yml {
-		kstr, ok := k.(string)
+func (c *compiler) compileMap(n *yaml.Node) Expr {
+	return c.compileActions(n.Content)
+}
+
+type mapExpr struct {
+	subs      map[string]Expr
+	staticVal map[string]interface{}
+	static    bool
+}
+
+func newMapExpr(subs map[string]Expr) *mapExpr {
+	static := true
+	for _, v := range subs {
+		static = static && v.Static()
+	}
+
+	me := mapExpr{static: static}
+	if static {
+		me.staticVal = make(map[string]interface{})
+		for k, v := range subs {
+			me.staticVal[k] = v.Eval(context.Background())
+		}
+	} else {
+		me.subs = subs
+	}
+	return &me
+}
+
+func (me *mapExpr) Eval(ctx context.Context) interface{} {
+	if me.Static() {
+		return me.staticVal
+	}
+
+	ret := make(map[string]interface{})
+	for k, v := range me.subs {
+		ret[k] = v.Eval(ctx)
+	}
+	return ret
+}
+
+func (me *mapExpr) Static() bool {
+	return me.static
+}
+
+// compileValue compiles n as the value of a description.
+// Unlike actions, mappings in values evaluate to a
+// map[string]interface{}, which is interpreted by the description.
+func (c *compiler) compileValue(n *yaml.Node) Expr {
+	n = resolveNode(n)
+	switch n.Kind {
+	case yaml.SequenceNode:
+		var subs []Expr
+		ok := true
+		for i, v := range n.Content {
+			c.push(strconv.Itoa(i))
+			sub := c.compileValue(v)
+			c.pop()
+			ok = ok && sub != nil
+			subs = append(subs, sub)
+		}
+		if !ok {
+			return nil
+		}
+		return newSliceExpr(subs)
+	case yaml.MappingNode:
+		subs := make(map[string]Expr)
+		ok := true
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			kstr, isStr := keyString(k)
+			if !isStr {
+				c.fail(k, "", ErrKeyNotString)
+				ok = false
+				continue
+			}
+			if _, dup := subs[kstr]; dup {
+				c.fail(k, kstr, ErrDuplicateKey)
+				ok = false
+				continue
+			}
+
+			c.push(kstr)
+			sub := c.compileValue(v)
+			c.pop()
+			ok = ok && sub != nil
+			subs[kstr] = sub
+		}
 		if !ok {
-			return nil, "key must be a string"
+			return nil
+		}
+		return newMapExpr(subs)
+	}
+	return c.compile(n)
+}
+
+// splitMapping separates the entries of the mapping n whose keys are
+// descriptions from the remaining entries, which are actions.
+// Descriptions may not repeat.
+func (c *compiler) splitMapping(n *yaml.Node, keys ...string) (map[string]*yaml.Node, []*yaml.Node, bool) {
+	descs := make(map[string]*yaml.Node)
+	var remaining []*yaml.Node
+	ok := true
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		kstr, isStr := keyString(k)
+		if !isStr {
+			// on gets parsed to true by YAML 1.1, we pretend that doesn't happen
+			rk := resolveNode(k)
+			if rk.ShortTag() != "!!bool" || rk.Value != "true" {
+				c.fail(k, "", ErrKeyNotString)
+				ok = false
+				continue
+			}
+			kstr = "on"
+		}
+
+		isDesc := false
+		for _, key := range keys {
+			isDesc = isDesc || key == kstr
+		}
+		if !isDesc {
+			remaining = append(remaining, k, v)
+			continue
+		}
+
+		if _, dup := descs[kstr]; dup {
+			c.fail(k, kstr, ErrDuplicateKey)
+			ok = false
+			continue
+		}
+		descs[kstr] = v
+	}
+	return descs, remaining, ok
+}
+
+// compileDescs compiles the values of descs, in the order of keys.
+func (c *compiler) compileDescs(descs map[string]*yaml.Node, keys ...string) (map[string]Expr, bool) {
+	exprs := make(map[string]Expr)
+	ok := true
+	for _, key := range keys {
+		v, has := descs[key]
+		if !has {
+			continue
+		}
+
+		c.push(key)
+		expr := c.compileValue(v)
+		c.pop()
+		if expr == nil {
+			ok = false
+			continue
+		}
+		exprs[key] = expr
+	}
+	return exprs, ok
+}
+
+// failExpr records err from a new*Expr function.
+// A descError is reported at the value of its description in descs,
+// other errors at n.
+func (c *compiler) failExpr(n *yaml.Node, descs map[string]*yaml.Node, err error) {
+	var de descError
+	if errors.As(err, &de) {
+		if v, ok := descs[de.key]; ok {
+			c.fail(v, de.key, de.err)
+			return
+		}
+	}
+	c.fail(n, "", err)
+}
+
+// compileActions compiles the key and value pairs of a mapping
+// as actions, which execute in document order.
+// Keys may not repeat, a sequence repeats actions instead.
+func (c *compiler) compileActions(pairs []*yaml.Node) Expr {
+	var subs []Expr
+	ok := true
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		k, v := pairs[i], pairs[i+1]
+		kstr, isStr := keyString(k)
+		if !isStr {
+			c.fail(k, "", ErrKeyNotString)
+			ok = false
+			continue
+		}
+		if seen[kstr] {
+			c.fail(k, kstr, ErrDuplicateKey)
+			ok = false
+			continue
 		}
+		seen[kstr] = true
 
-		var sub Expr
-		var err string
+		var compileAction func(*yaml.Node) Expr
 		switch kstr {
 		case "do":
-			sub, err = compileDo(v)
+			compileAction = c.compileDo
 		case "repeat":
-			sub, err = compileRepeat(v)
+			compileAction = c.compileRepeat
+		case "toggle":
+			compileAction = c.compileToggle
 		case "press":
-			sub, err = compilePress(v)
+			compileAction = c.compilePress
+		case "tap":
+			compileAction = c.compileTap
+		case "type":
+			compileAction = c.compileType
 		case "hold":
-			sub, err = compileHold(v)
+			compileAction = c.compileHold
+		case "wait":
+			compileAction = c.compileWait
 		case "release":
-			sub, err = compileRelease(v)
+			compileAction = c.compileRelease
+		case "remap":
+			compileAction = c.compileRemap
+		case "move":
+			compileAction = c.compileMove
+		case "drag":
+			compileAction = c.compileDrag
+		case "scroll":
+			compileAction = c.compileScroll
 		case "file":
-			sub, err = compileFile(v)
+			compileAction = c.compileFile
 		default:
-			return nil, "invalid key " + kstr
-		}
-		if err != "" {
-			return nil, addErrorTrace(err, kstr)
+			c.fail(k, kstr, ErrInvalidKey)
+			ok = false
+			continue
 		}
+
+		c.push(kstr)
+		sub := compileAction(v)
+		c.pop()
+		ok = ok && sub != nil
 		subs = append(subs, sub)
 	}
 
+	if !ok {
+		return nil
+	}
+
 	// maps are treated identical to slices after compilation
-	return newSliceExpr(subs), ""
+	return newSliceExpr(subs)
 }
 
 // parseInput parses val as a slice of Inputs.
@@ -223,123 +608,299 @@ func parseInput(val interface{}, defaultFlag uint64) ([]Input, error) {
 	return nil, errors.New("cannot parse as Input")
 }
 
+// doMode is what do does when triggered while its actions are running.
+type doMode int
+
+const (
+	modeIgnore   doMode = iota // ignore the trigger
+	modeQueue                  // run the actions again once they finish
+	modeRestart                // cancel the actions and run them again
+	modeParallel               // run the actions again alongside
+)
+
+// errMaxMode is the error of max with a mode it does not limit.
+var errMaxMode = errors.New("max requires mode queue or parallel")
+
+// limited reports whether max applies to mode.
+func (mode doMode) limited() bool {
+	return mode == modeQueue || mode == modeParallel
+}
+
+// parseMode parses val as a doMode.
+// Accepts ignore, queue, restart and parallel.
+func parseMode(val interface{}) (doMode, error) {
+	switch val {
+	case "ignore":
+		return modeIgnore, nil
+	case "queue":
+		return modeQueue, nil
+	case "restart":
+		return modeRestart, nil
+	case "parallel":
+		return modeParallel, nil
+	}
+	return 0, errors.New("mode must be ignore, queue, restart or parallel")
+}
+
 type doExpr struct {
-	onExpr     Expr
-	actionExpr Expr
-	staticOn   []Input
+	e           *Engine
+	onExpr      Expr
+	blockExpr   Expr
+	modeExpr    Expr
+	maxExpr     Expr
+	actionExpr  Expr
+	staticOn    trigger
+	staticBlock bool
+	staticKeys  []int // keys blocked by a static on
+	staticMode  doMode
+	staticMax   int // 0 if there is no max
 }
 
-func newDoExpr(onExpr, actionExpr Expr) (*doExpr, string) {
-	de := &doExpr{actionExpr: actionExpr}
+func newDoExpr(e *Engine, onExpr, blockExpr, modeExpr, maxExpr, actionExpr Expr) (*doExpr, error) {
+	de := &doExpr{e: e, actionExpr: actionExpr}
+	keys := []string{"block", "mode", "max"}
+	for i, expr := range []Expr{blockExpr, modeExpr, maxExpr} {
+		if expr != nil && onExpr == nil {
+			return nil, descError{keys[i], fmt.Errorf("%v requires on", keys[i])}
+		}
+	}
+
+	if modeExpr != nil && modeExpr.Static() {
+		mode, err := parseMode(modeExpr.Eval(context.Background()))
+		if err != nil {
+			return nil, descError{"mode", err}
+		}
+		de.staticMode = mode
+	} else {
+		de.modeExpr = modeExpr
+	}
+
+	if maxExpr != nil && maxExpr.Static() {
+		max, err := parseCount(maxExpr.Eval(context.Background()))
+		if err != nil {
+			return nil, descError{"max", err}
+		}
+		de.staticMax = max
+	} else {
+		de.maxExpr = maxExpr
+	}
+	if maxExpr != nil && de.modeExpr == nil && !de.staticMode.limited() {
+		return nil, descError{"max", errMaxMode}
+	}
+
+	if blockExpr != nil && blockExpr.Static() {
+		block, ok := blockExpr.Eval(context.Background()).(bool)
+		if !ok {
+			return nil, descError{"block", errors.New("block must be a bool")}
+		}
+		de.staticBlock = block
+	} else {
+		de.blockExpr = blockExpr
+	}
+
 	if onExpr != nil && onExpr.Static() {
-		val := onExpr.Eval()
-		inputs, err := parseInput(val, KeyDown)
+		val := onExpr.Eval(context.Background())
+		install, err := parseTrigger(e, val)
 		if err != nil {
-			return nil, err.Error()
+			return nil, descError{"on", err}
+		}
+		de.staticOn = install
+
+		if de.staticBlock || de.blockExpr != nil {
+			keys, err := triggerKeys(val)
+			if err != nil {
+				return nil, descError{"block", err}
+			}
+			de.staticKeys = keys
 		}
-		de.staticOn = inputs
 	} else {
 		de.onExpr = onExpr
 	}
-	return de, ""
+	return de, nil
 }
 
-func (de *doExpr) Eval() interface{} {
+func (de *doExpr) Eval(ctx context.Context) interface{} {
 	if de.Static() {
 		return nil
 	}
 
 	// If there is no trigger, do is identity.
 	if de.onExpr == nil && de.staticOn == nil {
-		de.actionExpr.Eval()
+		de.actionExpr.Eval(ctx)
 		return nil
 	}
 
+	block := de.staticBlock
+	if de.blockExpr != nil {
+		val := de.blockExpr.Eval(ctx)
+		var ok bool
+		block, ok = val.(bool)
+		if !ok {
+			evalError("block", val, errors.New("block must be a bool"))
+		}
+	}
+
 	var err error
-	inputs := de.staticOn
-	if inputs == nil {
-		val := de.onExpr.Eval()
-		inputs, err = parseInput(val, KeyDown)
+	install, keys := de.staticOn, de.staticKeys
+	if install == nil {
+		val := de.onExpr.Eval(ctx)
+		install, err = parseTrigger(de.e, val)
 		if err != nil {
-			panic(fmt.Sprintf("bad value for on: %v", val))
+			evalError("on", val, err)
+		}
+		if block {
+			keys, err = triggerKeys(val)
+			if err != nil {
+				evalError("block", val, err)
+			}
 		}
 	}
 
-	ch := make(chan Input)
-	NotifyOn(ch, inputs...)
-	go func() {
-		for range ch {
-			de.actionExpr.Eval()
+	mode := de.staticMode
+	if de.modeExpr != nil {
+		val := de.modeExpr.Eval(ctx)
+		mode, err = parseMode(val)
+		if err != nil {
+			evalError("mode", val, err)
+		}
+	}
+
+	max := de.staticMax
+	if de.maxExpr != nil {
+		val := de.maxExpr.Eval(ctx)
+		max, err = parseCount(val)
+		if err != nil {
+			evalError("max", val, err)
+		}
+	}
+	if max != 0 && !mode.limited() {
+		evalError("max", max, errMaxMode)
+	}
+
+	unblock := func() {}
+	if block {
+		unblock, err = de.e.block(keys)
+		if err != nil {
+			evalError("block", keys, err)
 		}
+	}
+
+	// The trigger listeners live as long as ctx, same as the goroutine.
+	// Fires are buffered so that those close together are all served.
+	ch := make(chan struct{}, inputsBuffer)
+	install(ctx, ch)
+	go func() {
+		defer unblock()
+		de.serve(ctx, ch, mode, max)
 	}()
 
 	return nil
 }
 
+// serve runs the actions whenever ch receives until ctx is done,
+// then waits for the running actions to finish.
+// With the modes queue and parallel, max limits the queued or
+// running actions respectively.
+func (de *doExpr) serve(ctx context.Context, ch <-chan struct{}, mode doMode, max int) {
+	running, queued := 0, 0
+	stop := func() {} // cancels the last actions started with restart
+	finished := make(chan struct{})
+
+	// A run is finished once its actions return, but what they started
+	// in the background, such as a nested do, lives on until ctx is done
+	// or the run is restarted.
+	start := func() {
+		runCtx := ctx
+		if mode == modeRestart {
+			stop()
+			runCtx, stop = context.WithCancel(ctx)
+		}
+		running++
+		go func() {
+			defer func() { finished <- struct{}{} }()
+			if err := de.e.run(runCtx, de.actionExpr); err != nil {
+				de.e.reportError(err)
+			}
+		}()
+	}
+
+	for {
+		select {
+		case <-ch:
+			switch {
+			case running == 0:
+				start()
+			case mode == modeQueue:
+				if max == 0 || queued < max {
+					queued++
+				}
+			case mode == modeRestart:
+				stop()
+				<-finished
+				running--
+				start()
+			case mode == modeParallel:
+				if max == 0 || running < max {
+					start()
+				}
+			}
+		case <-finished:
+			running--
+			if running == 0 && queued > 0 {
+				queued--
+				start()
+			}
+		case <-ctx.Done():
+			for ; running > 0; running-- {
+				<-finished
+			}
+			return
+		}
+	}
+}
+
 func (de *doExpr) Static() bool {
 	return de.actionExpr == nil && de.actionExpr.Static()
 }
 
 // compileDo compiles the map value with key "do".
 // Compiles by special-casing the "on" key as the trigger
-// and delegating to compileMap for the remaining.
-func compileDo(yml interface{}) (Expr, string) {
-	var m map[interface{}]interface{}
-	switch yml := yml.(type) {
-	case map[interface{}]interface{}:
-		m = yml
-	case []interface{}:
-		return compileSlice(yml)
+// and delegating to compileActions for the remaining.
+func (c *compiler) compileDo(n *yaml.Node) Expr {
+	n = resolveNode(n)
+	switch n.Kind {
+	case yaml.MappingNode:
+	case yaml.SequenceNode:
+		return c.compileSlice(n)
 	default:
-		return nil, "value must be a mapping or sequence"
+		c.fail(n, "", errors.New("value must be a mapping or sequence"))
+		return nil
 	}
 
-	var onExpr Expr
-	remaining := make(map[interface{}]interface{})
-	for k, v := range m {
-		var kstr string
-		switch k.(type) {
-		case string:
-			kstr = k.(string)
-		case bool:
-			if k.(bool) == true {
-				// on gets parsed to true, we pretend that doesn't happen
-				kstr = "on"
-			} else {
-				return nil, "key must be a string"
-			}
-		default:
-			return nil, "key must be a string"
-		}
-
-		switch kstr {
-		case "on":
-			expr, err := compile(v)
-			if err != "" {
-				return nil, addErrorTrace(err, kstr)
-			}
-			onExpr = expr
-		default:
-			remaining[k] = v
-		}
+	descs, remaining, ok := c.splitMapping(n, "on", "block", "mode", "max")
+	exprs, descsOk := c.compileDescs(descs, "on", "block", "mode", "max")
+	actionExpr := c.compileActions(remaining)
+	if !ok || !descsOk {
+		return nil
 	}
 
-	actionExpr, err := compileMap(remaining)
-	if err != "" {
-		return nil, err
+	// Descriptions are checked even if the actions have errors.
+	de, err := newDoExpr(c.e, exprs["on"], exprs["block"], exprs["mode"], exprs["max"], actionExpr)
+	if err != nil {
+		c.failExpr(n, descs, err)
+		return nil
 	}
-
-	de, err := newDoExpr(onExpr, actionExpr)
-	if err != "" {
-		return nil, addErrorTrace(err, "on")
+	if actionExpr == nil {
+		return nil
 	}
-	return de, ""
+	return de
 }
 
 type hertz float64
 
 // parseFreq parses val as a frequency.
-// Accepts strings.
+// Accepts strings of positive frequencies up to 1ghz whose period
+// fits in a time.Duration, so that it is at least a nanosecond.
 func parseFreq(val interface{}) (hertz, error) {
 	s, ok := val.(string)
 	if !ok {
@@ -355,6 +916,15 @@ func parseFreq(val interface{}) (hertz, error) {
 	if err != nil {
 		return 0, err
 	}
+	if !(freq > 0) {
+		return 0, errors.New("frequency must be positive")
+	}
+	if freq > 1e9 {
+		return 0, errors.New("frequency cannot exceed 1ghz")
+	}
+	if float64(time.Second)/freq >= math.MaxInt64 {
+		return 0, errors.New("frequency is too low")
+	}
 	return hertz(freq), nil
 }
 
@@ -369,86 +939,71 @@ func parseDuration(val interface{}) (time.Duration, error) {
 	return time.ParseDuration(s)
 }
 
-// parseTrigger parses val as a trigger which sends to ch.
-// Returns an installation function.
-// Accepts strings or slices.
-func parseTrigger(val interface{}, ch chan<- struct{}) (func(), error) {
-	// TODO: uninstall function?
-	switch yml := val.(type) {
-	case string:
-		inputs, err := parseInput(yml, KeyDown)
-		if err != nil {
-			return nil, err
-		}
+// parseCount parses val as a count.
+// Accepts positive ints.
+func parseCount(val interface{}) (int, error) {
+	n, ok := val.(int)
+	if !ok || n <= 0 {
+		return 0, errors.New("must be a positive integer")
+	}
+	return n, nil
+}
 
-		return func() {
-			in := make(chan Input)
-			NotifyOn(in, inputs...)
-			go func() {
-				for range in {
-					select {
-					case ch <- struct{}{}:
-					default:
-					}
-				}
-			}()
-		}, nil
-	case []interface{}:
-		var installs []func()
-		for _, v := range yml {
-			install, err := parseTrigger(v, ch)
-			if err != nil {
-				return nil, err
-			}
-			installs = append(installs, install)
+// parseWhile parses val as the keys a repeat continues while held.
+// Accepts what parseInput accepts without up or down.
+func parseWhile(val interface{}) ([]int, error) {
+	inputs, err := parseInput(val, 0)
+	if err != nil {
+		return nil, err
+	}
+	var keys []int
+	for _, v := range inputs {
+		if v.Flag != 0 {
+			return nil, errors.New("while cannot be suffixed with up or down")
 		}
-		return func() {
-			for _, v := range installs {
-				v()
-			}
-		}, nil
+		keys = append(keys, v.Key)
 	}
-
-	return nil, errors.New("cannot parse as trigger")
+	return keys, nil
 }
 
 type repeatExpr struct {
+	e           *Engine
 	atExpr      Expr
 	forExpr     Expr
 	untilExpr   Expr
+	timesExpr   Expr
+	whileExpr   Expr
 	actionExpr  Expr
 	staticAt    hertz
 	staticFor   time.Duration
-	staticUntil func()
-	untilCh     chan struct{}
+	staticUntil trigger
+	staticTimes int
+	staticWhile []int
 }
 
-func newRepeatExpr(atExpr, forExpr, untilExpr, actionExpr Expr) (*repeatExpr, string) {
-	re := &repeatExpr{
-		actionExpr: actionExpr,
-		untilCh:    make(chan struct{}),
-	}
+func newRepeatExpr(e *Engine, atExpr, forExpr, untilExpr, timesExpr, whileExpr, actionExpr Expr) (*repeatExpr, error) {
+	re := &repeatExpr{e: e, actionExpr: actionExpr}
 
 	if atExpr.Static() {
-		val := atExpr.Eval()
+		val := atExpr.Eval(context.Background())
 		freq, err := parseFreq(val)
 		if err != nil {
-			return nil, err.Error()
+			return nil, descError{"at", err}
 		}
 		re.staticAt = freq
 	} else {
 		re.atExpr = atExpr
 	}
 
-	if untilExpr == nil && forExpr == nil {
-		return nil, "must contain either until or for"
+	if untilExpr == nil && forExpr == nil && timesExpr == nil && whileExpr == nil {
+		return nil, errors.New("must contain any of until, for, times or while")
 	}
 
 	if untilExpr != nil && untilExpr.Static() {
-		val := untilExpr.Eval()
-		install, err := parseTrigger(val, re.untilCh)
+		val := untilExpr.Eval(context.Background())
+		install, err := parseTrigger(e, val)
 		if err != nil {
-			return nil, err.Error()
+			return nil, descError{"until", err}
 		}
 		re.staticUntil = install
 	} else {
@@ -456,25 +1011,45 @@ func newRepeatExpr(atExpr, forExpr, untilExpr, actionExpr Expr) (*repeatExpr, st
 	}
 
 	if forExpr != nil && forExpr.Static() {
-		val := forExpr.Eval()
+		val := forExpr.Eval(context.Background())
 		dur, err := parseDuration(val)
 		if err != nil {
-			return nil, err.Error()
+			return nil, descError{"for", err}
 		}
 		if dur <= 0 {
-			return nil, "duration has to be positive"
+			return nil, descError{"for", errors.New("duration has to be positive")}
 		}
 		re.staticFor = dur
 	} else {
 		re.forExpr = forExpr
 	}
 
-	return re, ""
-}
-
-func (re *repeatExpr) Eval() interface{} {
-	if re.Static() {
-		return nil
+	if timesExpr != nil && timesExpr.Static() {
+		times, err := parseCount(timesExpr.Eval(context.Background()))
+		if err != nil {
+			return nil, descError{"times", err}
+		}
+		re.staticTimes = times
+	} else {
+		re.timesExpr = timesExpr
+	}
+
+	if whileExpr != nil && whileExpr.Static() {
+		keys, err := parseWhile(whileExpr.Eval(context.Background()))
+		if err != nil {
+			return nil, descError{"while", err}
+		}
+		re.staticWhile = keys
+	} else {
+		re.whileExpr = whileExpr
+	}
+
+	return re, nil
+}
+
+func (re *repeatExpr) Eval(ctx context.Context) interface{} {
+	if re.Static() {
+		return nil
 	}
 
 	var freq hertz
@@ -482,21 +1057,21 @@ func (re *repeatExpr) Eval() interface{} {
 	if re.atExpr == nil {
 		freq = re.staticAt
 	} else {
-		val := re.atExpr.Eval()
+		val := re.atExpr.Eval(ctx)
 		freq, err = parseFreq(val)
 		if err != nil {
-			panic(fmt.Sprintf("bad value for at: %v", val))
+			evalError("at", val, err)
 		}
 	}
 
-	var install func()
+	var install trigger
 	if re.untilExpr == nil {
 		install = re.staticUntil
 	} else {
-		val := re.untilExpr.Eval()
-		install, err = parseTrigger(val, re.untilCh)
+		val := re.untilExpr.Eval(ctx)
+		install, err = parseTrigger(re.e, val)
 		if err != nil {
-			panic(fmt.Sprintf("bad value for until: %v", val))
+			evalError("until", val, err)
 		}
 	}
 
@@ -504,153 +1079,430 @@ func (re *repeatExpr) Eval() interface{} {
 	if re.forExpr == nil {
 		dur = re.staticFor
 	} else {
-		val := re.forExpr.Eval()
+		val := re.forExpr.Eval(ctx)
 		dur, err = parseDuration(val)
-		if err != nil || dur <= 0 {
-			panic(fmt.Sprintf("bad value for for: %v", val))
+		if err != nil {
+			evalError("for", val, err)
+		}
+		if dur <= 0 {
+			evalError("for", val, errors.New("duration has to be positive"))
+		}
+	}
+
+	times := re.staticTimes
+	if re.timesExpr != nil {
+		val := re.timesExpr.Eval(ctx)
+		times, err = parseCount(val)
+		if err != nil {
+			evalError("times", val, err)
+		}
+	}
+
+	keys := re.staticWhile
+	if re.whileExpr != nil {
+		val := re.whileExpr.Eval(ctx)
+		keys, err = parseWhile(val)
+		if err != nil {
+			evalError("while", val, err)
 		}
 	}
 
+	// The until listeners live as long as this repeat.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	limits := repeatLimits{dur: dur, times: times}
 	if install != nil {
-		install()
+		untilCh := make(chan struct{}, 1)
+		install(ctx, untilCh)
+		limits.until = untilCh
+	}
+	if keys != nil {
+		limits.while = func() bool {
+			return re.e.anyPressed(keys)
+		}
 	}
+	repeatLoop(ctx, freq, limits, re.actionExpr)
+	return nil
+}
+
+// repeatLimits are the conditions ending a repeatLoop.
+// Zero values do not limit it.
+type repeatLimits struct {
+	dur   time.Duration   // time until it ends
+	times int             // number of evaluations
+	until <-chan struct{} // receives when it ends
+	while func() bool     // returns false when it ends, checked before evaluating
+}
+
+// repeatLoop evaluates action at freq until ctx is done or limits end it.
+func repeatLoop(ctx context.Context, freq hertz, limits repeatLimits, action Expr) {
 	ticker := time.NewTicker(time.Duration(float64(time.Second) / float64(freq)))
 	defer ticker.Stop()
 
-	if dur > 0 {
-		timer := time.NewTimer(dur)
-		for {
-			select {
-			case <-re.untilCh:
-				return nil
-			case <-timer.C:
-				return nil
-			case <-ticker.C:
-				re.actionExpr.Eval()
+	var timeout <-chan time.Time
+	if limits.dur > 0 {
+		timer := time.NewTimer(limits.dur)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for n := 0; limits.times == 0 || n < limits.times; n++ {
+		select {
+		case <-limits.until:
+			return
+		case <-timeout:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if limits.while != nil && !limits.while() {
+				return
 			}
+			action.Eval(ctx)
+		}
+	}
+}
+
+func (re *repeatExpr) Static() bool {
+	return re.actionExpr == nil || re.actionExpr.Static()
+}
+
+func (c *compiler) compileRepeat(n *yaml.Node) Expr {
+	n = resolveNode(n)
+	if n.Kind != yaml.MappingNode {
+		c.fail(n, "", errors.New("value must be a mapping"))
+		return nil
+	}
+
+	descs, remaining, ok := c.splitMapping(n, "at", "for", "until", "times", "while")
+	exprs, descsOk := c.compileDescs(descs, "at", "for", "until", "times", "while")
+	if _, has := descs["at"]; !has {
+		c.fail(n, "at", ErrMissingKey)
+		ok = false
+	}
+	actionExpr := c.compileActions(remaining)
+	if !ok || !descsOk {
+		return nil
+	}
+
+	// Descriptions are checked even if the actions have errors.
+	re, err := newRepeatExpr(c.e, exprs["at"], exprs["for"], exprs["until"], exprs["times"], exprs["while"], actionExpr)
+	if err != nil {
+		c.failExpr(n, descs, err)
+		return nil
+	}
+	if actionExpr == nil {
+		return nil
+	}
+	return re
+}
+
+type toggleExpr struct {
+	e          *Engine
+	onExpr     Expr
+	atExpr     Expr
+	actionExpr Expr
+	staticOn   trigger
+	staticAt   hertz // 0 if there is no at
+}
+
+func newToggleExpr(e *Engine, onExpr, atExpr, actionExpr Expr) (*toggleExpr, error) {
+	te := &toggleExpr{e: e, actionExpr: actionExpr}
+	if onExpr.Static() {
+		val := onExpr.Eval(context.Background())
+		install, err := parseTrigger(e, val)
+		if err != nil {
+			return nil, descError{"on", err}
+		}
+		te.staticOn = install
+	} else {
+		te.onExpr = onExpr
+	}
+
+	if atExpr != nil && atExpr.Static() {
+		val := atExpr.Eval(context.Background())
+		freq, err := parseFreq(val)
+		if err != nil {
+			return nil, descError{"at", err}
 		}
+		te.staticAt = freq
 	} else {
+		te.atExpr = atExpr
+	}
+	return te, nil
+}
+
+// Eval installs the trigger, which alternates between starting the
+// actions and cancelling them until ctx is done. With at, the actions
+// repeat until cancelled, otherwise they run once and what they start
+// in the background lasts until cancelled.
+func (te *toggleExpr) Eval(ctx context.Context) interface{} {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	var err error
+	install := te.staticOn
+	if install == nil {
+		val := te.onExpr.Eval(ctx)
+		install, err = parseTrigger(te.e, val)
+		if err != nil {
+			evalError("on", val, err)
+		}
+	}
+
+	freq := te.staticAt
+	if te.atExpr != nil {
+		val := te.atExpr.Eval(ctx)
+		freq, err = parseFreq(val)
+		if err != nil {
+			evalError("at", val, err)
+		}
+	}
+
+	action := te.actionExpr
+	if freq != 0 {
+		action = funcExpr(func(ctx context.Context) {
+			repeatLoop(ctx, freq, repeatLimits{}, te.actionExpr)
+		})
+	}
+
+	ch := make(chan struct{}, inputsBuffer)
+	install(ctx, ch)
+	go func() {
+		var stop context.CancelFunc // cancels the actions, nil when off
+		var finished chan struct{}  // closed when the actions return
 		for {
 			select {
-			case <-re.untilCh:
-				return nil
-			case <-ticker.C:
-				re.actionExpr.Eval()
+			case <-ch:
+				if stop != nil {
+					stop()
+					if finished != nil {
+						<-finished
+					}
+					stop, finished = nil, nil
+					continue
+				}
+
+				var runCtx context.Context
+				runCtx, stop = context.WithCancel(ctx)
+				finished = make(chan struct{})
+				go func(finished chan struct{}) {
+					defer close(finished)
+					if err := te.e.run(runCtx, action); err != nil {
+						te.e.reportError(err)
+					}
+				}(finished)
+			case <-finished:
+				// What the actions started in the background, such as
+				// remap, lasts until toggled off.
+				finished = nil
+			case <-ctx.Done():
+				if stop != nil {
+					stop()
+				}
+				if finished != nil {
+					<-finished
+				}
+				return
 			}
 		}
+	}()
+
+	return nil
+}
+
+func (te *toggleExpr) Static() bool {
+	return false
+}
+
+func (c *compiler) compileToggle(n *yaml.Node) Expr {
+	n = resolveNode(n)
+	if n.Kind != yaml.MappingNode {
+		c.fail(n, "", errors.New("value must be a mapping"))
+		return nil
+	}
+
+	descs, remaining, ok := c.splitMapping(n, "on", "at")
+	exprs, descsOk := c.compileDescs(descs, "on", "at")
+	if _, has := descs["on"]; !has {
+		c.fail(n, "on", ErrMissingKey)
+		ok = false
+	}
+	actionExpr := c.compileActions(remaining)
+	if !ok || !descsOk {
+		return nil
+	}
+
+	// Descriptions are checked even if the actions have errors.
+	te, err := newToggleExpr(c.e, exprs["on"], exprs["at"], actionExpr)
+	if err != nil {
+		c.failExpr(n, descs, err)
+		return nil
+	}
+	if actionExpr == nil {
+		return nil
 	}
+	return te
 }
 
-func (re *repeatExpr) Static() bool {
-	return re.actionExpr == nil || re.actionExpr.Static()
+// funcExpr adapts a function to an Expr, so that it can run as actions.
+type funcExpr func(ctx context.Context)
+
+func (fe funcExpr) Eval(ctx context.Context) interface{} {
+	fe(ctx)
+	return nil
+}
+
+func (fe funcExpr) Static() bool {
+	return false
+}
+
+// sendOrFail sends input through e for the action key,
+// aborting it on failure. Keys held down are recorded for the run of ctx.
+func sendOrFail(ctx context.Context, e *Engine, key string, input Input) {
+	err := e.Send(input)
+	if err != nil {
+		evalError(key, input, err)
+	}
+	heldFrom(ctx).record(input)
 }
 
-func compileRepeat(yml interface{}) (Expr, string) {
-	m, ok := yml.(map[interface{}]interface{})
+// pressing is what press and tap press.
+type pressing struct {
+	inputs []Input
+	delay  time.Duration // between successive inputs
+	hold   time.Duration // between the downs and the ups
+}
+
+// parsePress parses val as a pressing.
+// Accepts what parseInput accepts, or a mapping with the keys
+// keys, accepted by parseInput, and the optional durations delay and hold.
+func parsePress(val interface{}) (pressing, error) {
+	m, ok := val.(map[string]interface{})
 	if !ok {
-		return nil, "value must be a mapping"
+		inputs, err := parseInput(val, 0)
+		return pressing{inputs: inputs}, err
 	}
 
-	var (
-		atExpr    Expr
-		forExpr   Expr
-		untilExpr Expr
-	)
-	remaining := make(map[interface{}]interface{})
-	for k, v := range m {
-		kstr, ok := k.(string)
-		if !ok {
-			return nil, "key must be a string"
+	for k := range m {
+		if !hasString([]string{"keys", "delay", "hold"}, k) {
+			return pressing{}, fmt.Errorf("%v: %w", k, ErrInvalidKey)
 		}
+	}
+	if m["keys"] == nil {
+		return pressing{}, fmt.Errorf("keys: %w", ErrMissingKey)
+	}
 
-		switch kstr {
-		case "at":
-			Expr, err := compile(v)
-			if err != "" {
-				return nil, addErrorTrace(err, kstr)
-			}
-			atExpr = Expr
-		case "for":
-			Expr, err := compile(v)
-			if err != "" {
-				return nil, addErrorTrace(err, kstr)
-			}
-			forExpr = Expr
-		case "until":
-			Expr, err := compile(v)
-			if err != "" {
-				return nil, addErrorTrace(err, kstr)
-			}
-			untilExpr = Expr
-		default:
-			remaining[k] = v
+	var p pressing
+	var err error
+	p.inputs, err = parseInput(m["keys"], 0)
+	if err != nil {
+		return pressing{}, err
+	}
+	if v, has := m["delay"]; has {
+		p.delay, err = parseDuration(v)
+		if err != nil {
+			return pressing{}, fmt.Errorf("delay: %w", err)
 		}
 	}
-
-	if atExpr == nil {
-		return nil, "missing at"
+	if v, has := m["hold"]; has {
+		p.hold, err = parseDuration(v)
+		if err != nil {
+			return pressing{}, fmt.Errorf("hold: %w", err)
+		}
 	}
-
-	actionExpr, err := compileMap(remaining)
-	if err != "" {
-		return nil, err
+	if p.delay < 0 || p.hold < 0 {
+		return pressing{}, errors.New("duration cannot be negative")
 	}
+	return p, nil
+}
 
-	re, err := newRepeatExpr(atExpr, forExpr, untilExpr, actionExpr)
-	if err != "" {
-		return nil, err
+// sleep waits for d or until ctx is done, whichever is first.
+// It returns false if ctx is done.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
 	}
 
-	return re, ""
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
 type pressExpr struct {
+	e      *Engine
 	expr   Expr
-	static []Input
+	static *pressing
 }
 
-func newPressExpr(expr Expr) (*pressExpr, string) {
-	pe := &pressExpr{}
+func newPressExpr(e *Engine, expr Expr) (*pressExpr, error) {
+	pe := &pressExpr{e: e}
 	if expr.Static() {
-		val := expr.Eval()
-		inputs, err := parseInput(val, 0)
+		val := expr.Eval(context.Background())
+		p, err := parsePress(val)
 		if err != nil {
-			return nil, err.Error()
+			return nil, err
 		}
-		pe.static = inputs
+		pe.static = &p
 	} else {
 		pe.expr = expr
 	}
 
-	return pe, ""
+	return pe, nil
 }
 
-func (pe *pressExpr) Eval() interface{} {
-	inputs := pe.static
-	var err error
-	if inputs == nil {
-		val := pe.expr.Eval()
-		inputs, err = parseInput(val, 0)
+func (pe *pressExpr) Eval(ctx context.Context) interface{} {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	var p pressing
+	if pe.static != nil {
+		p = *pe.static
+	} else {
+		val := pe.expr.Eval(ctx)
+		var err error
+		p, err = parsePress(val)
 		if err != nil {
-			panic(fmt.Sprintf("bad value for press: %v", val))
+			evalError("press", val, err)
 		}
 	}
 
 	// Specifying nothing means keydown and keyup for press.
 	// Order is keydown over all inputs before keyup to allow
 	// key combinations such as ctrl + c.
-	for _, input := range inputs {
+	for i, input := range p.inputs {
+		if i > 0 && !sleep(ctx, p.delay) {
+			return nil
+		}
 		if input.Flag == 0 {
 			input.Flag = KeyDown
 		}
-		Send(input)
+		sendOrFail(ctx, pe.e, "press", input)
 	}
 
-	for _, input := range inputs {
-		if input.Flag == 0 {
-			input.Flag = KeyUp
-			Send(input)
+	if !sleep(ctx, p.hold) {
+		return nil
+	}
+
+	first := true
+	for _, input := range p.inputs {
+		if input.Flag != 0 {
+			continue
+		}
+		if !first && !sleep(ctx, p.delay) {
+			return nil
 		}
+		first = false
+		input.Flag = KeyUp
+		sendOrFail(ctx, pe.e, "press", input)
 	}
 	return nil
 }
@@ -659,54 +1511,198 @@ func (pe *pressExpr) Static() bool {
 	return false
 }
 
-func compilePress(yml interface{}) (Expr, string) {
-	expr, err := compile(yml)
-	if err != "" {
-		return nil, err
+func (c *compiler) compilePress(n *yaml.Node) Expr {
+	expr := c.compileValue(n)
+	if expr == nil {
+		return nil
 	}
 
-	pe, err := newPressExpr(expr)
-	if err != "" {
-		return nil, err
+	pe, err := newPressExpr(c.e, expr)
+	if err != nil {
+		c.fail(n, "", err)
+		return nil
+	}
+
+	return pe
+}
+
+type tapExpr struct {
+	e      *Engine
+	expr   Expr
+	static *pressing
+}
+
+func newTapExpr(e *Engine, expr Expr) (*tapExpr, error) {
+	te := &tapExpr{e: e}
+	if expr.Static() {
+		val := expr.Eval(context.Background())
+		p, err := parsePress(val)
+		if err != nil {
+			return nil, err
+		}
+		te.static = &p
+	} else {
+		te.expr = expr
+	}
+
+	return te, nil
+}
+
+func (te *tapExpr) Eval(ctx context.Context) interface{} {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	var p pressing
+	if te.static != nil {
+		p = *te.static
+	} else {
+		val := te.expr.Eval(ctx)
+		var err error
+		p, err = parsePress(val)
+		if err != nil {
+			evalError("tap", val, err)
+		}
+	}
+
+	// Unlike press, each input is released before the next is pressed.
+	for i, input := range p.inputs {
+		if i > 0 && !sleep(ctx, p.delay) {
+			return nil
+		}
+		if input.Flag != 0 {
+			sendOrFail(ctx, te.e, "tap", input)
+			continue
+		}
+
+		sendOrFail(ctx, te.e, "tap", Input{Key: input.Key, Flag: KeyDown})
+		if !sleep(ctx, p.hold) {
+			return nil
+		}
+		sendOrFail(ctx, te.e, "tap", Input{Key: input.Key, Flag: KeyUp})
+	}
+	return nil
+}
+
+func (te *tapExpr) Static() bool {
+	return false
+}
+
+func (c *compiler) compileTap(n *yaml.Node) Expr {
+	expr := c.compileValue(n)
+	if expr == nil {
+		return nil
+	}
+
+	te, err := newTapExpr(c.e, expr)
+	if err != nil {
+		c.fail(n, "", err)
+		return nil
+	}
+
+	return te
+}
+
+type waitExpr struct {
+	expr   Expr
+	static time.Duration
+}
+
+func newWaitExpr(expr Expr) (*waitExpr, error) {
+	we := &waitExpr{}
+	if expr.Static() {
+		val := expr.Eval(context.Background())
+		dur, err := parseDuration(val)
+		if err != nil {
+			return nil, err
+		}
+		if dur < 0 {
+			return nil, errors.New("duration cannot be negative")
+		}
+		we.static = dur
+	} else {
+		we.expr = expr
+	}
+
+	return we, nil
+}
+
+func (we *waitExpr) Eval(ctx context.Context) interface{} {
+	dur := we.static
+	if we.expr != nil {
+		val := we.expr.Eval(ctx)
+		var err error
+		dur, err = parseDuration(val)
+		if err != nil {
+			evalError("wait", val, err)
+		}
+		if dur < 0 {
+			evalError("wait", val, errors.New("duration cannot be negative"))
+		}
+	}
+
+	sleep(ctx, dur)
+	return nil
+}
+
+func (we *waitExpr) Static() bool {
+	return false
+}
+
+func (c *compiler) compileWait(n *yaml.Node) Expr {
+	expr := c.compile(n)
+	if expr == nil {
+		return nil
+	}
+
+	we, err := newWaitExpr(expr)
+	if err != nil {
+		c.fail(n, "", err)
+		return nil
 	}
 
-	return pe, ""
+	return we
 }
 
 type holdExpr struct {
+	e      *Engine
 	expr   Expr
 	static []Input
 }
 
-func newHoldExpr(expr Expr) (*holdExpr, string) {
-	he := &holdExpr{}
+func newHoldExpr(e *Engine, expr Expr) (*holdExpr, error) {
+	he := &holdExpr{e: e}
 	if expr.Static() {
-		val := expr.Eval()
+		val := expr.Eval(context.Background())
 		inputs, err := parseInput(val, KeyDown)
 		if err != nil {
-			return nil, err.Error()
+			return nil, err
 		}
 		he.static = inputs
 	} else {
 		he.expr = expr
 	}
 
-	return he, ""
+	return he, nil
 }
 
-func (he *holdExpr) Eval() interface{} {
+func (he *holdExpr) Eval(ctx context.Context) interface{} {
+	if ctx.Err() != nil {
+		return nil
+	}
+
 	inputs := he.static
 	var err error
 	if inputs == nil {
-		val := he.expr.Eval()
+		val := he.expr.Eval(ctx)
 		inputs, err = parseInput(val, KeyDown)
 		if err != nil {
-			panic(fmt.Sprintf("bad value for hold: %v", val))
+			evalError("hold", val, err)
 		}
 	}
 
 	for _, input := range inputs {
-		Send(input)
+		sendOrFail(ctx, he.e, "hold", input)
 	}
 	return nil
 }
@@ -715,54 +1711,60 @@ func (he *holdExpr) Static() bool {
 	return false
 }
 
-func compileHold(yml interface{}) (Expr, string) {
-	expr, err := compile(yml)
-	if err != "" {
-		return nil, err
+func (c *compiler) compileHold(n *yaml.Node) Expr {
+	expr := c.compile(n)
+	if expr == nil {
+		return nil
 	}
 
-	he, err := newHoldExpr(expr)
-	if err != "" {
-		return nil, err
+	he, err := newHoldExpr(c.e, expr)
+	if err != nil {
+		c.fail(n, "", err)
+		return nil
 	}
 
-	return he, ""
+	return he
 }
 
 type releaseExpr struct {
+	e      *Engine
 	expr   Expr
 	static []Input
 }
 
-func newReleaseExpr(expr Expr) (*releaseExpr, string) {
-	re := &releaseExpr{}
+func newReleaseExpr(e *Engine, expr Expr) (*releaseExpr, error) {
+	re := &releaseExpr{e: e}
 	if expr.Static() {
-		val := expr.Eval()
+		val := expr.Eval(context.Background())
 		inputs, err := parseInput(val, KeyUp)
 		if err != nil {
-			return nil, err.Error()
+			return nil, err
 		}
 		re.static = inputs
 	} else {
 		re.expr = expr
 	}
 
-	return re, ""
+	return re, nil
 }
 
-func (re *releaseExpr) Eval() interface{} {
+func (re *releaseExpr) Eval(ctx context.Context) interface{} {
+	if ctx.Err() != nil {
+		return nil
+	}
+
 	inputs := re.static
 	var err error
 	if inputs == nil {
-		val := re.expr.Eval()
-		inputs, err = parseInput(val, KeyDown)
+		val := re.expr.Eval(ctx)
+		inputs, err = parseInput(val, KeyUp)
 		if err != nil {
-			panic(fmt.Sprintf("bad value for hold: %v", val))
+			evalError("release", val, err)
 		}
 	}
 
 	for _, input := range inputs {
-		Send(input)
+		sendOrFail(ctx, re.e, "release", input)
 	}
 	return nil
 }
@@ -771,110 +1773,857 @@ func (re *releaseExpr) Static() bool {
 	return false
 }
 
-func compileRelease(yml interface{}) (Expr, string) {
-	expr, err := compile(yml)
-	if err != "" {
-		return nil, err
+func (c *compiler) compileRelease(n *yaml.Node) Expr {
+	expr := c.compile(n)
+	if expr == nil {
+		return nil
 	}
 
-	re, err := newReleaseExpr(expr)
-	if err != "" {
-		return nil, err
+	re, err := newReleaseExpr(c.e, expr)
+	if err != nil {
+		c.fail(n, "", err)
+		return nil
+	}
+
+	return re
+}
+
+// remapping replaces the inputs of a key with those of other keys.
+type remapping struct {
+	from int
+	to   []int
+}
+
+// parseRemap parses val as remappings.
+// Accepts a mapping from keys to a key or a sequence of keys,
+// which are pressed in order and released in reverse.
+func parseRemap(val interface{}) ([]remapping, error) {
+	m, ok := val.(map[string]interface{})
+	if !ok || len(m) == 0 {
+		return nil, errors.New("remap must be a non-empty mapping")
+	}
+
+	var froms []string
+	for k := range m {
+		froms = append(froms, k)
+	}
+	sort.Strings(froms)
+
+	var remaps []remapping
+	seen := make(map[int]bool)
+	for _, k := range froms {
+		from, err := parseInput(k, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(from) != 1 || from[0].Flag != 0 {
+			return nil, fmt.Errorf("cannot remap %v", k)
+		}
+		for _, v := range withSides(from) {
+			if seen[v.Key] {
+				return nil, fmt.Errorf("%v is remapped more than once", k)
+			}
+			seen[v.Key] = true
+		}
+
+		to, err := parseInput(m[k], 0)
+		if err != nil {
+			return nil, err
+		}
+		r := remapping{from: from[0].Key}
+		for _, v := range to {
+			if v.Flag != 0 {
+				return nil, fmt.Errorf("cannot remap %v to up or down", k)
+			}
+			r.to = append(r.to, v.Key)
+		}
+		remaps = append(remaps, r)
 	}
+	return remaps, nil
+}
 
-	return re, ""
+type remapExpr struct {
+	e      *Engine
+	expr   Expr
+	static []remapping
+}
+
+func newRemapExpr(e *Engine, expr Expr) (*remapExpr, error) {
+	re := &remapExpr{e: e}
+	if expr.Static() {
+		val := expr.Eval(context.Background())
+		remaps, err := parseRemap(val)
+		if err != nil {
+			return nil, err
+		}
+		re.static = remaps
+	} else {
+		re.expr = expr
+	}
+
+	return re, nil
+}
+
+// Eval blocks the remapped keys and sends the keys they are remapped to
+// in their place until ctx is done.
+func (re *remapExpr) Eval(ctx context.Context) interface{} {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	remaps := re.static
+	var err error
+	if remaps == nil {
+		val := re.expr.Eval(ctx)
+		remaps, err = parseRemap(val)
+		if err != nil {
+			evalError("remap", val, err)
+		}
+	}
+
+	var keys []int
+	var inputs []Input
+	to := make(map[int][]int)
+	for _, r := range remaps {
+		keys = append(keys, r.from)
+		for _, v := range withSides([]Input{{Key: r.from}}) {
+			inputs = append(inputs, Input{Key: v.Key, Flag: KeyDown}, Input{Key: v.Key, Flag: KeyUp})
+			to[v.Key] = r.to
+		}
+	}
+
+	unblock, err := re.e.block(keys)
+	if err != nil {
+		evalError("remap", keys, err)
+	}
+
+	// Remapped inputs must not be dropped, or keys would be left down.
+	in := make(chan Input, inputsBuffer)
+	cancel := re.e.NotifyOnBlocking(in, inputs...)
+	// send sends flag for the keys from is remapped to.
+	// Only those still held are released if held is not nil.
+	send := func(from int, flag uint64, held map[int]bool) {
+		keys := to[from]
+		for i := range keys {
+			k := keys[i]
+			if flag == KeyUp {
+				k = keys[len(keys)-1-i]
+			}
+			if held != nil && !held[k] {
+				continue
+			}
+			err := re.e.Send(Input{Key: k, Flag: flag})
+			if err != nil {
+				re.e.reportError(&RuntimeError{Key: "remap", Value: k, Err: err})
+			}
+		}
+	}
+
+	go func() {
+		defer unblock()
+		defer cancel()
+
+		// Eval has returned by now, so the keys of remapped keys still
+		// down are released here, unless already released by Engine.Eval.
+		down := make(map[int]bool)
+		defer func() {
+			held := re.e.heldKeys()
+			for from := range down {
+				send(from, KeyUp, held)
+			}
+		}()
+
+		for {
+			select {
+			case input := <-in:
+				if input.Injected {
+					continue
+				}
+				send(input.Key, input.Flag, nil)
+				if input.Flag == KeyDown {
+					down[input.Key] = true
+				} else {
+					delete(down, input.Key)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (re *remapExpr) Static() bool {
+	return false
+}
+
+func (c *compiler) compileRemap(n *yaml.Node) Expr {
+	expr := c.compileValue(n)
+	if expr == nil {
+		return nil
+	}
+
+	re, err := newRemapExpr(c.e, expr)
+	if err != nil {
+		c.fail(n, "", err)
+		return nil
+	}
+
+	return re
+}
+
+// parsePoint parses val as a sequence of two ints.
+func parsePoint(val interface{}) (x, y int, err error) {
+	s, ok := val.([]interface{})
+	if !ok || len(s) != 2 {
+		return 0, 0, errors.New("must be a sequence of x and y")
+	}
+	x, okx := s[0].(int)
+	y, oky := s[1].(int)
+	if !okx || !oky {
+		return 0, 0, errors.New("x and y must be integers")
+	}
+	return x, y, nil
+}
+
+// moving is how move and drag move the cursor.
+type moving struct {
+	input Input         // MoveTo or MoveBy the whole way
+	dur   time.Duration // time taken, zero moves at once
+	freq  hertz         // steps per second over dur
+	curve bool          // bows the path instead of a straight line
+}
+
+// defaultMoveFreq is the step rate of moves over a duration,
+// about the rate of common displays.
+const defaultMoveFreq hertz = 60
+
+// moveKeys are the keys parsed by parseMoving.
+var moveKeys = []string{"to", "by", "for", "at", "path"}
+
+// parseMoving parses the mapping m as a moving.
+// It has exactly one of the keys to, the position on screen, or by, the
+// motion relative to the cursor, both of which are accepted by parsePoint.
+// Optionally, for is the duration of the move, at the rate of its steps
+// and path either line or curve.
+func parseMoving(m map[string]interface{}) (moving, error) {
+	mv := moving{input: Input{Key: Mouse}, freq: defaultMoveFreq}
+	var key string
+	switch {
+	case m["to"] != nil && m["by"] != nil:
+		return moving{}, errors.New("cannot have both to and by")
+	case m["to"] != nil:
+		key, mv.input.Flag = "to", MoveTo
+	case m["by"] != nil:
+		key, mv.input.Flag = "by", MoveBy
+	default:
+		return moving{}, fmt.Errorf("to or by: %w", ErrMissingKey)
+	}
+
+	var err error
+	mv.input.X, mv.input.Y, err = parsePoint(m[key])
+	if err != nil {
+		return moving{}, fmt.Errorf("%v: %w", key, err)
+	}
+
+	for _, k := range []string{"at", "path"} {
+		if m[k] != nil && m["for"] == nil {
+			return moving{}, fmt.Errorf("%v requires for", k)
+		}
+	}
+	if v, has := m["for"]; has {
+		mv.dur, err = parseDuration(v)
+		if err != nil {
+			return moving{}, fmt.Errorf("for: %w", err)
+		}
+		if mv.dur < 0 {
+			return moving{}, errors.New("duration cannot be negative")
+		}
+	}
+	if v, has := m["at"]; has {
+		mv.freq, err = parseFreq(v)
+		if err != nil {
+			return moving{}, fmt.Errorf("at: %w", err)
+		}
+	}
+	if v, has := m["path"]; has {
+		switch v {
+		case "line":
+		case "curve":
+			mv.curve = true
+		default:
+			return moving{}, fmt.Errorf("path: %v is not line or curve", v)
+		}
+	}
+	return mv, nil
+}
+
+// parseMove parses val as a moving.
+// Accepts a mapping as accepted by parseMoving.
+func parseMove(val interface{}) (moving, error) {
+	m, ok := val.(map[string]interface{})
+	if !ok {
+		return moving{}, errors.New("move must be a mapping with to or by")
+	}
+
+	for k := range m {
+		if !hasString(moveKeys, k) {
+			return moving{}, fmt.Errorf("%v: %w", k, ErrInvalidKey)
+		}
+	}
+	return parseMoving(m)
+}
+
+// point returns where the path of mv is at t from 0 to 1,
+// relative to its start for an end dx, dy away.
+func (mv moving) point(t float64, dx, dy float64) (x, y float64) {
+	if !mv.curve {
+		return t * dx, t * dy
+	}
+
+	// A quadratic bezier whose control point is off the middle of the
+	// line by a quarter of its length, to the left of the direction.
+	cx, cy := dx/2-dy/4, dy/2+dx/4
+	return 2*(1-t)*t*cx + t*t*dx, 2*(1-t)*t*cy + t*t*dy
+}
+
+// sendMoving moves the cursor through e as specified by mv
+// for the action key. It returns false if ctx is done before the end.
+func sendMoving(ctx context.Context, e *Engine, key string, mv moving) bool {
+	if mv.dur <= 0 {
+		sendOrFail(ctx, e, key, mv.input)
+		return true
+	}
+
+	// The path is relative to where the cursor starts, which must be
+	// known to move to a position.
+	var x0, y0 int
+	dx, dy := mv.input.X, mv.input.Y
+	if mv.input.Flag == MoveTo {
+		var err error
+		x0, y0, err = e.cursor()
+		if err != nil {
+			evalError(key, mv.input, err)
+		}
+		dx, dy = dx-x0, dy-y0
+	}
+
+	steps := int(math.Round(mv.dur.Seconds() * float64(mv.freq)))
+	if steps < 1 {
+		steps = 1
+	}
+
+	// Steps are timed from the start so that sending does not add up.
+	start := time.Now()
+	var px, py int
+	for i := 1; i <= steps; i++ {
+		at := start.Add(mv.dur * time.Duration(i) / time.Duration(steps))
+		if !sleep(ctx, time.Until(at)) {
+			return false
+		}
+
+		fx, fy := mv.point(float64(i)/float64(steps), float64(dx), float64(dy))
+		x, y := int(math.Round(fx)), int(math.Round(fy))
+		if x == px && y == py {
+			continue
+		}
+
+		input := mv.input
+		if input.Flag == MoveTo {
+			input.X, input.Y = x0+x, y0+y
+		} else {
+			input.X, input.Y = x-px, y-py
+		}
+		sendOrFail(ctx, e, key, input)
+		px, py = x, y
+	}
+	return true
+}
+
+type moveExpr struct {
+	e      *Engine
+	expr   Expr
+	static *moving
+}
+
+func newMoveExpr(e *Engine, expr Expr) (*moveExpr, error) {
+	me := &moveExpr{e: e}
+	if expr.Static() {
+		val := expr.Eval(context.Background())
+		mv, err := parseMove(val)
+		if err != nil {
+			return nil, err
+		}
+		me.static = &mv
+	} else {
+		me.expr = expr
+	}
+
+	return me, nil
+}
+
+func (me *moveExpr) Eval(ctx context.Context) interface{} {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	var mv moving
+	if me.static != nil {
+		mv = *me.static
+	} else {
+		val := me.expr.Eval(ctx)
+		var err error
+		mv, err = parseMove(val)
+		if err != nil {
+			evalError("move", val, err)
+		}
+	}
+
+	sendMoving(ctx, me.e, "move", mv)
+	return nil
+}
+
+func (me *moveExpr) Static() bool {
+	return false
+}
+
+func (c *compiler) compileMove(n *yaml.Node) Expr {
+	expr := c.compileValue(n)
+	if expr == nil {
+		return nil
+	}
+
+	me, err := newMoveExpr(c.e, expr)
+	if err != nil {
+		c.fail(n, "", err)
+		return nil
+	}
+
+	return me
+}
+
+// dragging is what drag presses and where it moves.
+type dragging struct {
+	button int
+	from   *Input // MoveTo before pressing, if any
+	moving
+}
+
+// parseDrag parses val as a dragging.
+// Accepts a mapping as accepted by parseMoving, with the optional keys
+// button, the key held down which defaults to left click, and from,
+// the position to press at which defaults to the cursor.
+func parseDrag(val interface{}) (dragging, error) {
+	m, ok := val.(map[string]interface{})
+	if !ok {
+		return dragging{}, errors.New("drag must be a mapping with to or by")
+	}
+
+	for k := range m {
+		if !hasString(moveKeys, k) && k != "button" && k != "from" {
+			return dragging{}, fmt.Errorf("%v: %w", k, ErrInvalidKey)
+		}
+	}
+
+	var d dragging
+	var err error
+	d.moving, err = parseMoving(m)
+	if err != nil {
+		return dragging{}, err
+	}
+
+	d.button = LeftClick
+	if v, has := m["button"]; has {
+		inputs, err := parseInput(v, 0)
+		if err != nil {
+			return dragging{}, fmt.Errorf("button: %w", err)
+		}
+		if len(inputs) != 1 || inputs[0].Flag != 0 {
+			return dragging{}, fmt.Errorf("button: cannot drag with %v", v)
+		}
+		d.button = inputs[0].Key
+	}
+	if v, has := m["from"]; has {
+		from := Input{Key: Mouse, Flag: MoveTo}
+		from.X, from.Y, err = parsePoint(v)
+		if err != nil {
+			return dragging{}, fmt.Errorf("from: %w", err)
+		}
+		d.from = &from
+	}
+	return d, nil
+}
+
+type dragExpr struct {
+	e      *Engine
+	expr   Expr
+	static *dragging
+}
+
+func newDragExpr(e *Engine, expr Expr) (*dragExpr, error) {
+	de := &dragExpr{e: e}
+	if expr.Static() {
+		val := expr.Eval(context.Background())
+		d, err := parseDrag(val)
+		if err != nil {
+			return nil, err
+		}
+		de.static = &d
+	} else {
+		de.expr = expr
+	}
+
+	return de, nil
+}
+
+// Eval presses the button, moves and releases it. If ctx is done in
+// between, the button is released by run as other held keys are.
+func (de *dragExpr) Eval(ctx context.Context) interface{} {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	var d dragging
+	if de.static != nil {
+		d = *de.static
+	} else {
+		val := de.expr.Eval(ctx)
+		var err error
+		d, err = parseDrag(val)
+		if err != nil {
+			evalError("drag", val, err)
+		}
+	}
+
+	if d.from != nil {
+		sendOrFail(ctx, de.e, "drag", *d.from)
+	}
+	sendOrFail(ctx, de.e, "drag", Input{Key: d.button, Flag: KeyDown})
+	if !sendMoving(ctx, de.e, "drag", d.moving) {
+		return nil
+	}
+	sendOrFail(ctx, de.e, "drag", Input{Key: d.button, Flag: KeyUp})
+	return nil
+}
+
+func (de *dragExpr) Static() bool {
+	return false
+}
+
+func (c *compiler) compileDrag(n *yaml.Node) Expr {
+	expr := c.compileValue(n)
+	if expr == nil {
+		return nil
+	}
+
+	de, err := newDragExpr(c.e, expr)
+	if err != nil {
+		c.fail(n, "", err)
+		return nil
+	}
+
+	return de
+}
+
+// parseScroll parses val as the number of notches to scroll,
+// positive away from the user.
+func parseScroll(val interface{}) (int, error) {
+	delta, ok := val.(int)
+	if !ok {
+		return 0, errors.New("scroll must be an integer")
+	}
+	if delta == 0 {
+		return 0, errors.New("scroll cannot be zero")
+	}
+	return delta, nil
+}
+
+type scrollExpr struct {
+	e      *Engine
+	expr   Expr
+	static int
+}
+
+func newScrollExpr(e *Engine, expr Expr) (*scrollExpr, error) {
+	se := &scrollExpr{e: e}
+	if expr.Static() {
+		val := expr.Eval(context.Background())
+		delta, err := parseScroll(val)
+		if err != nil {
+			return nil, err
+		}
+		se.static = delta
+	} else {
+		se.expr = expr
+	}
+
+	return se, nil
+}
+
+func (se *scrollExpr) Eval(ctx context.Context) interface{} {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	delta := se.static
+	if se.expr != nil {
+		val := se.expr.Eval(ctx)
+		var err error
+		delta, err = parseScroll(val)
+		if err != nil {
+			evalError("scroll", val, err)
+		}
+	}
+
+	sendOrFail(ctx, se.e, "scroll", Input{Key: Mouse, Flag: Scroll, Delta: delta})
+	return nil
+}
+
+func (se *scrollExpr) Static() bool {
+	return false
+}
+
+func (c *compiler) compileScroll(n *yaml.Node) Expr {
+	expr := c.compile(n)
+	if expr == nil {
+		return nil
+	}
+
+	se, err := newScrollExpr(c.e, expr)
+	if err != nil {
+		c.fail(n, "", err)
+		return nil
+	}
+
+	return se
+}
+
+// typing is what type types.
+type typing struct {
+	text   string
+	layout layout
+	delay  time.Duration // between successive characters
+}
+
+// parseType parses val as a typing.
+// Accepts a string typed on a US layout, or a mapping with the keys
+// text, the string, and the optional layout and delay.
+func parseType(val interface{}) (typing, error) {
+	if s, ok := val.(string); ok {
+		return typing{text: s, layout: layouts["us"]}, nil
+	}
+	m, ok := val.(map[string]interface{})
+	if !ok {
+		return typing{}, errors.New("type must be a string or a mapping with text")
+	}
+
+	for k := range m {
+		if !hasString([]string{"text", "layout", "delay"}, k) {
+			return typing{}, fmt.Errorf("%v: %w", k, ErrInvalidKey)
+		}
+	}
+	if m["text"] == nil {
+		return typing{}, fmt.Errorf("text: %w", ErrMissingKey)
+	}
+
+	t := typing{layout: layouts["us"]}
+	t.text, ok = m["text"].(string)
+	if !ok {
+		return typing{}, errors.New("text: must be a string")
+	}
+	var err error
+	if v, has := m["layout"]; has {
+		t.layout, err = parseLayout(v)
+		if err != nil {
+			return typing{}, fmt.Errorf("layout: %w", err)
+		}
+	}
+	if v, has := m["delay"]; has {
+		t.delay, err = parseDuration(v)
+		if err != nil {
+			return typing{}, fmt.Errorf("delay: %w", err)
+		}
+		if t.delay < 0 {
+			return typing{}, errors.New("duration cannot be negative")
+		}
+	}
+	return t, nil
+}
+
+type typeExpr struct {
+	e      *Engine
+	expr   Expr
+	static *typing
+}
+
+func newTypeExpr(e *Engine, expr Expr) (*typeExpr, error) {
+	te := &typeExpr{e: e}
+	if expr.Static() {
+		val := expr.Eval(context.Background())
+		t, err := parseType(val)
+		if err != nil {
+			return nil, err
+		}
+		te.static = &t
+	} else {
+		te.expr = expr
+	}
+
+	return te, nil
+}
+
+// Eval types the characters on the layout with their keys, and the rest
+// directly if the backend supports it. Nothing is typed if some
+// characters cannot be.
+func (te *typeExpr) Eval(ctx context.Context) interface{} {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	var t typing
+	if te.static != nil {
+		t = *te.static
+	} else {
+		val := te.expr.Eval(ctx)
+		var err error
+		t, err = parseType(val)
+		if err != nil {
+			evalError("type", val, err)
+		}
+	}
+
+	var u sys.Unicoder
+	for _, r := range t.text {
+		if _, ok := t.layout[r]; ok {
+			continue
+		}
+		var err error
+		u, err = te.e.unicoder()
+		if err != nil {
+			evalError("type", string(r), fmt.Errorf("%q is not on the layout: %w", r, err))
+		}
+		break
+	}
+
+	first := true
+	for _, r := range t.text {
+		if !first && !sleep(ctx, t.delay) {
+			return nil
+		}
+		first = false
+
+		s, ok := t.layout[r]
+		if !ok {
+			err := u.SendUnicode(r)
+			if err != nil {
+				evalError("type", string(r), err)
+			}
+			continue
+		}
+		for _, input := range s.inputs() {
+			sendOrFail(ctx, te.e, "type", input)
+		}
+	}
+	return nil
+}
+
+func (te *typeExpr) Static() bool {
+	return false
+}
+
+func (c *compiler) compileType(n *yaml.Node) Expr {
+	expr := c.compileValue(n)
+	if expr == nil {
+		return nil
+	}
+
+	te, err := newTypeExpr(c.e, expr)
+	if err != nil {
+		c.fail(n, "", err)
+		return nil
+	}
+
+	return te
 }
 
 type fileExpr struct {
+	e          *Engine
 	expr       Expr        // expr is not static
 	staticExpr Expr        // expr is static, but file content is not
 	staticVal  interface{} // both expr and file content is static
 }
 
-func newFileExpr(expr Expr) (*fileExpr, string) {
-	fe := &fileExpr{}
+// newFileExpr compiles the file of a static expr with errors reported
+// under the keys in parent.
+func newFileExpr(e *Engine, expr Expr, parent []string) (*fileExpr, error) {
+	fe := &fileExpr{e: e}
 	if expr.Static() {
-		val := expr.Eval()
+		val := expr.Eval(context.Background())
 		path, ok := val.(string)
 		if !ok {
-			return nil, "file path must be a string"
-		}
-		f, err := os.Open(path)
-		if err != nil {
-			return nil, err.Error()
-		}
-		defer f.Close()
-
-		var m interface{}
-		err = yaml.NewDecoder(f).Decode(&m)
-		if err != nil {
-			return nil, err.Error()
+			return nil, errors.New("file path must be a string")
 		}
 
-		mexpr, err := Compile(m)
+		mexpr, err := compileSource(e, path, parent)
 		if err != nil {
-			return nil, err.Error()
+			return nil, err
 		}
 
 		if mexpr.Static() {
-			fe.staticVal = mexpr.Eval()
+			fe.staticVal = mexpr.Eval(context.Background())
 		} else {
 			fe.staticExpr = mexpr
 		}
 	} else {
 		fe.expr = expr
 	}
-	return fe, ""
+	return fe, nil
 }
 
-func (fe *fileExpr) Eval() interface{} {
+func (fe *fileExpr) Eval(ctx context.Context) interface{} {
 	if fe.staticVal != nil {
 		return fe.staticVal
 	}
 
 	if fe.staticExpr != nil {
-		return fe.staticExpr.Eval()
+		return fe.staticExpr.Eval(ctx)
 	}
 
-	val := fe.expr.Eval()
+	val := fe.expr.Eval(ctx)
 	path, ok := val.(string)
 	if !ok {
-		panic(fmt.Sprintf("bad value for file: %v", val))
-	}
-	f, err := os.Open(path)
-	if err != nil {
-		panic(err)
-	}
-	defer f.Close()
-
-	var m map[interface{}]interface{}
-	err = yaml.NewDecoder(f).Decode(&m)
-	if err != nil {
-		panic(err)
+		evalError("file", val, errors.New("file path must be a string"))
 	}
-
-	mexpr, err := Compile(m)
+	mexpr, err := compileSource(fe.e, path, nil)
 	if err != nil {
-		panic(err)
+		evalError("file", val, err)
 	}
 
-	return mexpr.Eval()
+	return mexpr.Eval(ctx)
 }
 
 func (fe *fileExpr) Static() bool {
 	return fe.staticVal != nil
 }
 
-func compileFile(yml interface{}) (Expr, string) {
-	expr, err := compile(yml)
-	if err != "" {
-		return nil, err
+func (c *compiler) compileFile(n *yaml.Node) Expr {
+	expr := c.compile(n)
+	if expr == nil {
+		return nil
 	}
 
-	fe, err := newFileExpr(expr)
-	if err != "" {
-		return nil, err
+	// Errors in the file itself are reported at their own position,
+	// others at the file key.
+	fe, err := newFileExpr(c.e, expr, c.path)
+	if err != nil {
+		if errs, ok := err.(CompileErrors); ok {
+			c.errs = append(c.errs, errs...)
+		} else {
+			c.fail(n, "", err)
+		}
+		return nil
 	}
 
-	return fe, ""
+	return fe
 }
@@ -0,0 +1,201 @@
+package autokey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Sinacam/autokey/sys"
+	"gopkg.in/yaml.v3"
+)
+
+// evalOnSim compiles src on an Engine over a Sim and evaluates it.
+// Actions in the background, such as by do, run until the test ends.
+func evalOnSim(t *testing.T, src string) *sys.Sim {
+	t.Helper()
+	s := sys.NewSim()
+	e := NewEngine()
+	e.InitBackend(s)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		e.Teardown()
+	})
+
+	var n yaml.Node
+	if err := yaml.Unmarshal([]byte(src), &n); err != nil {
+		t.Fatal(err)
+	}
+	expr, err := e.Compile(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Eval(ctx, expr); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// events returns the Events of records without their times.
+func events(records []sys.Record) []sys.Event {
+	var ret []sys.Event
+	for _, r := range records {
+		ret = append(ret, r.Event)
+	}
+	return ret
+}
+
+// expectSent waits for want to be sent, then checks nothing else is.
+func expectSent(t *testing.T, s *sys.Sim, want ...sys.Event) {
+	t.Helper()
+	s.WaitTranscript(len(want), time.Second)
+	time.Sleep(50 * time.Millisecond)
+	if got := events(s.Transcript()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("sent %v, want %v", got, want)
+	}
+	s.Reset()
+}
+
+func TestCompileDocumentOrder(t *testing.T) {
+	s := evalOnSim(t, `
+tap: b
+press: a
+hold: c
+release: c
+`)
+	expectSent(t, s, down('B'), up('B'), down('A'), up('A'), down('C'), up('C'))
+}
+
+func TestCompileErrors(t *testing.T) {
+	src := `
+do:
+  on: f6
+  mode: sideways
+press: nokey
+`
+	var n yaml.Node
+	if err := yaml.Unmarshal([]byte(src), &n); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewEngine().Compile(&n)
+
+	var errs CompileErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got %v, want CompileErrors", err)
+	}
+	// Every error is reported, with the line and column of its node.
+	want := []string{
+		"4:9: do.mode: mode must be ignore, queue, restart or parallel",
+		"5:8: press: cannot parse as Input",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("got %v errors, want %v:\n%v", len(errs), len(want), err)
+	}
+	for i := range want {
+		if got := errs[i].Error(); got != want[i] {
+			t.Errorf("got %q, want %q", got, want[i])
+		}
+	}
+}
+
+func TestCompileChord(t *testing.T) {
+	s := evalOnSim(t, `
+do:
+  on: ctrl+k
+  press: a
+`)
+	s.Inject(down('K'), up('K'))
+	expectSent(t, s)
+
+	s.Inject(down(LeftCtrl), down('K'), up('K'), up(LeftCtrl))
+	expectSent(t, s, down('A'), up('A'))
+}
+
+func TestCompileSequence(t *testing.T) {
+	s := evalOnSim(t, `
+do:
+  on:
+    sequence: [down, right, a]
+  press: b
+`)
+	// Another key in between starts the sequence over.
+	s.Inject(down(Down), up(Down), down('X'), up('X'), down(Right), up(Right), down('A'), up('A'))
+	expectSent(t, s)
+
+	s.Inject(down(Down), up(Down), down(Right), up(Right), down('A'), up('A'))
+	expectSent(t, s, down('B'), up('B'))
+}
+
+func TestCompileRepeatTimes(t *testing.T) {
+	s := evalOnSim(t, `
+repeat:
+  at: 100hz
+  times: 3
+  press: a
+`)
+	expectSent(t, s, down('A'), up('A'), down('A'), up('A'), down('A'), up('A'))
+}
+
+func TestCompileRepeatWhile(t *testing.T) {
+	s := evalOnSim(t, `
+do:
+  on: f6
+  repeat:
+    at: 100hz
+    while: f6
+    press: a
+`)
+	s.Inject(down(F6))
+	if got := s.WaitTranscript(4, time.Second); len(got) < 4 {
+		t.Fatalf("sent %v while held, want at least 4", len(got))
+	}
+	s.Inject(up(F6))
+	time.Sleep(50 * time.Millisecond)
+	n := len(s.Transcript())
+	time.Sleep(100 * time.Millisecond)
+	if got := len(s.Transcript()); got != n {
+		t.Fatalf("sent %v after release", got-n)
+	}
+}
+
+func TestCompileDoMode(t *testing.T) {
+	tests := []struct {
+		mode string
+		max  int
+		runs int
+	}{
+		{"ignore", 0, 1},
+		{"queue", 1, 2},
+		{"parallel", 2, 2},
+		{"restart", 0, 3},
+	}
+	for _, tt := range tests {
+		src := fmt.Sprintf("do:\n  on: f6\n  mode: %v\n", tt.mode)
+		if tt.max > 0 {
+			src += fmt.Sprintf("  max: %v\n", tt.max)
+		}
+		s := evalOnSim(t, src+"  press: {keys: a, hold: 100ms}\n")
+
+		// Triggers come while the first run holds a down. Every run
+		// presses a once, whether it finishes or is restarted.
+		for i := 0; i < 3; i++ {
+			s.Inject(down(F6), up(F6))
+			time.Sleep(30 * time.Millisecond)
+		}
+		s.WaitTranscript(2*tt.runs, time.Second)
+		time.Sleep(150 * time.Millisecond)
+		got := events(s.Transcript())
+		runs := 0
+		for _, e := range got {
+			if e == down('A') {
+				runs++
+			}
+		}
+		if runs != tt.runs {
+			t.Errorf("mode %v with max %v: sent %v, want %v runs", tt.mode, tt.max, got, tt.runs)
+		}
+	}
+}
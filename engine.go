@@ -0,0 +1,548 @@
+package autokey
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Sinacam/autokey/sys"
+)
+
+// subscriber is a channel registered by Notify or NotifyOn.
+// Subscribers are compared by pointer, the same channel may be
+// registered more than once.
+type subscriber struct {
+	ch    chan<- Input
+	block bool          // wait for ch to receive instead of dropping inputs
+	stop  chan struct{} // closed when unsubscribed
+}
+
+func newSubscriber(ch chan<- Input, block bool) *subscriber {
+	return &subscriber{ch: ch, block: block, stop: make(chan struct{})}
+}
+
+// removeSubscriber returns subs without sub.
+func removeSubscriber(subs []*subscriber, sub *subscriber) []*subscriber {
+	var ret []*subscriber
+	for _, v := range subs {
+		if v != sub {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// Engine monitors and sends inputs through a backend, and runs the
+// expressions compiled by it. Engines are independent of each other.
+// The package level functions use a default Engine.
+type Engine struct {
+	dropped uint64 // inputs dropped by dispatch, accessed atomically
+
+	mtx     sync.Mutex // guards the fields up to ctx
+	backend sys.Backend
+	owned   bool          // backend was opened by Init and is closed by Teardown
+	done    chan struct{} // closed by Teardown
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	notifyOn  map[uint64][]*subscriber
+	notify    []*subscriber
+	notifyMtx sync.RWMutex
+
+	held    map[int]bool // keys held down by Send
+	heldMtx sync.Mutex
+
+	pressed    map[int]bool // keys physically down according to the monitored inputs
+	pressedMtx sync.Mutex
+
+	errHandler func(error)
+	errMtx     sync.Mutex
+}
+
+// NewEngine returns an Engine which must be initialized before use.
+func NewEngine() *Engine {
+	return &Engine{
+		notifyOn: make(map[uint64][]*subscriber),
+		held:     make(map[int]bool),
+		pressed:  make(map[int]bool),
+	}
+}
+
+// Init must be called prior to Notify, NotifyOn, Send and Eval.
+// It uses the default backend of the platform.
+// An Engine may be initialized again after Teardown.
+func (e *Engine) Init() error {
+	backend, err := sys.Open("")
+	if err != nil {
+		return err
+	}
+	e.init(backend, true)
+	return nil
+}
+
+// InitBackend is Init with an explicit backend.
+// Teardown does not close backend.
+func (e *Engine) InitBackend(backend sys.Backend) {
+	e.init(backend, false)
+}
+
+func (e *Engine) init(backend sys.Backend, owned bool) {
+	done := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	e.mtx.Lock()
+	e.backend = backend
+	e.owned = owned
+	e.done = done
+	e.ctx = ctx
+	e.cancel = cancel
+	e.mtx.Unlock()
+
+	e.pressedMtx.Lock()
+	e.pressed = make(map[int]bool)
+	e.pressedMtx.Unlock()
+
+	go backend.SetGlobalHook()
+	go func() {
+		for {
+			ev := backend.GetInput()
+
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			// GetInput may return k == 0 right after initialization and after teardown
+			// as means to unblock.
+			if ev.Key == 0 {
+				continue
+			}
+
+			input := Input{
+				Key:      ev.Key,
+				Flag:     ev.Flag,
+				X:        ev.X,
+				Y:        ev.Y,
+				Delta:    ev.Delta,
+				Injected: ev.Injected,
+			}
+			e.track(input)
+			e.dispatch(input, done)
+		}
+	}()
+}
+
+// Teardown stops everything evaluated by e, releases the keys held down
+// by it and uninstalls the hook. It does nothing if e is not initialized
+// or is already torn down.
+func (e *Engine) Teardown() {
+	e.mtx.Lock()
+	backend, owned := e.backend, e.owned
+	if backend == nil || e.closing() {
+		e.mtx.Unlock()
+		return
+	}
+	close(e.done)
+	e.cancel()
+	e.mtx.Unlock()
+
+	e.releaseAll()
+	backend.Unhook()
+	if c, ok := backend.(io.Closer); ok && owned {
+		c.Close()
+	}
+
+	e.mtx.Lock()
+	e.backend = nil
+	e.mtx.Unlock()
+
+	e.notifyMtx.Lock()
+	defer e.notifyMtx.Unlock()
+	e.notifyOn = make(map[uint64][]*subscriber)
+	e.notify = nil
+}
+
+// closing reports whether Teardown is underway, e.mtx must be held.
+func (e *Engine) closing() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// rootContext returns the context cancelled by Teardown,
+// or nil if e is not initialized.
+func (e *Engine) rootContext() context.Context {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if e.backend == nil {
+		return nil
+	}
+	return e.ctx
+}
+
+// sides lists the keys reported in place of a generic modifier.
+var sides = map[int][]int{
+	Alt:   {LeftAlt, RightAlt},
+	Ctrl:  {LeftCtrl, RightCtrl},
+	Shift: {LeftShift, RightShift},
+}
+
+// track updates the key state with a monitored input.
+// It happens before dispatch, so subscribers see the state including input.
+// Injected inputs are not tracked, the state is of the physical keys.
+func (e *Engine) track(input Input) {
+	if input.Injected {
+		return
+	}
+
+	e.pressedMtx.Lock()
+	defer e.pressedMtx.Unlock()
+	switch input.Flag {
+	case KeyDown:
+		e.pressed[input.Key] = true
+	case KeyUp:
+		delete(e.pressed, input.Key)
+	}
+}
+
+// isPressed reports whether key is physically down.
+// A generic modifier is down if either of its sides is.
+func (e *Engine) isPressed(key int) bool {
+	e.pressedMtx.Lock()
+	defer e.pressedMtx.Unlock()
+	if e.pressed[key] {
+		return true
+	}
+	for _, k := range sides[key] {
+		if e.pressed[k] {
+			return true
+		}
+	}
+	return false
+}
+
+// anyPressed reports whether any of keys is down.
+func (e *Engine) anyPressed(keys []int) bool {
+	for _, k := range keys {
+		if e.isPressed(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// dispatch delivers input to its subscribers.
+// Subscribers are copied out before delivery so that blocking ones
+// may subscribe further without deadlocking.
+func (e *Engine) dispatch(input Input, done <-chan struct{}) {
+	e.notifyMtx.RLock()
+	subs := append([]*subscriber(nil), e.notify...)
+	subs = append(subs, e.notifyOn[input.asMapKey()]...)
+	e.notifyMtx.RUnlock()
+
+	for _, sub := range subs {
+		e.deliver(sub, input, done)
+	}
+}
+
+func (e *Engine) deliver(sub *subscriber, input Input, done <-chan struct{}) {
+	if sub.block {
+		select {
+		case sub.ch <- input:
+		case <-sub.stop:
+		case <-done:
+		}
+		return
+	}
+
+	select {
+	case sub.ch <- input:
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+// Dropped returns the number of inputs lost so far, either in the backend
+// before reaching e or when a subscriber was not ready to receive.
+func (e *Engine) Dropped() (hook, dispatch uint64) {
+	e.mtx.Lock()
+	backend := e.backend
+	e.mtx.Unlock()
+
+	if dc, ok := backend.(sys.DropCounter); ok {
+		hook = dc.Dropped()
+	}
+	return hook, atomic.LoadUint64(&e.dropped)
+}
+
+// Send sends input through the backend of e.
+func (e *Engine) Send(input Input) error {
+	e.mtx.Lock()
+	backend := e.backend
+	e.mtx.Unlock()
+
+	if backend == nil {
+		return ErrNotInitialized
+	}
+	err := backend.Send(sys.Event{
+		Key:   input.Key,
+		Flag:  input.Flag,
+		X:     input.X,
+		Y:     input.Y,
+		Delta: input.Delta,
+	})
+	if err != nil {
+		return err
+	}
+
+	e.heldMtx.Lock()
+	defer e.heldMtx.Unlock()
+	switch input.Flag {
+	case KeyDown:
+		e.held[input.Key] = true
+	case KeyUp:
+		delete(e.held, input.Key)
+	}
+	return nil
+}
+
+// block consumes the physical inputs of keys, and of the sides of
+// generic modifiers among them, until unblock is called.
+func (e *Engine) block(keys []int) (unblock func(), err error) {
+	e.mtx.Lock()
+	backend := e.backend
+	e.mtx.Unlock()
+
+	if backend == nil {
+		return nil, ErrNotInitialized
+	}
+	b, ok := backend.(sys.Blocker)
+	if !ok {
+		return nil, ErrBlockUnsupported
+	}
+
+	var blocked []int
+	release := func() {
+		for _, k := range blocked {
+			b.Unblock(k)
+		}
+	}
+	for _, key := range keys {
+		for _, k := range append([]int{key}, sides[key]...) {
+			err := b.Block(k)
+			if err != nil {
+				release()
+				return nil, err
+			}
+			blocked = append(blocked, k)
+		}
+	}
+
+	var once sync.Once
+	return func() { once.Do(release) }, nil
+}
+
+// cursor returns the position of the mouse cursor,
+// see sys.Cursor.
+func (e *Engine) cursor() (x, y int, err error) {
+	e.mtx.Lock()
+	backend := e.backend
+	e.mtx.Unlock()
+
+	if backend == nil {
+		return 0, 0, ErrNotInitialized
+	}
+	c, ok := backend.(sys.Cursor)
+	if !ok {
+		return 0, 0, sys.ErrUnsupported
+	}
+	return c.Cursor()
+}
+
+// unicoder returns the backend if it can type characters directly,
+// see sys.Unicoder.
+func (e *Engine) unicoder() (sys.Unicoder, error) {
+	e.mtx.Lock()
+	backend := e.backend
+	e.mtx.Unlock()
+
+	if backend == nil {
+		return nil, ErrNotInitialized
+	}
+	u, ok := backend.(sys.Unicoder)
+	if !ok {
+		return nil, sys.ErrUnsupported
+	}
+	return u, nil
+}
+
+// heldKeys returns the keys currently held down by Send.
+func (e *Engine) heldKeys() map[int]bool {
+	e.heldMtx.Lock()
+	defer e.heldMtx.Unlock()
+	held := make(map[int]bool)
+	for k := range e.held {
+		held[k] = true
+	}
+	return held
+}
+
+// releaseAll releases the keys held down by Send.
+func (e *Engine) releaseAll() {
+	for k := range e.heldKeys() {
+		e.Send(Input{Key: k, Flag: KeyUp})
+	}
+}
+
+// release releases the keys of held that are still held down by Send,
+// and forgets them in the enclosing sets.
+func (e *Engine) release(held *heldSet) {
+	for _, k := range held.take() {
+		up := Input{Key: k, Flag: KeyUp}
+		if e.heldKeys()[k] && e.Send(up) == nil {
+			held.parent.record(up)
+		}
+	}
+}
+
+// heldSet is the keys held down by the actions of a run, see Engine.run.
+// Keys are recorded in the sets of the enclosing runs too, so that they
+// are released along with those runs.
+type heldSet struct {
+	mtx    sync.Mutex
+	keys   map[int]bool
+	parent *heldSet
+}
+
+func newHeldSet(parent *heldSet) *heldSet {
+	return &heldSet{keys: make(map[int]bool), parent: parent}
+}
+
+// heldKey is the context key of the heldSet of a run.
+type heldKey struct{}
+
+// heldFrom returns the heldSet of the run of ctx, nil if there is none.
+func heldFrom(ctx context.Context) *heldSet {
+	held, _ := ctx.Value(heldKey{}).(*heldSet)
+	return held
+}
+
+// record records input sent by a run in held and its enclosing sets.
+// held may be nil.
+func (held *heldSet) record(input Input) {
+	for h := held; h != nil; h = h.parent {
+		h.mtx.Lock()
+		switch input.Flag {
+		case KeyDown:
+			h.keys[input.Key] = true
+		case KeyUp:
+			delete(h.keys, input.Key)
+		}
+		h.mtx.Unlock()
+	}
+}
+
+// take returns the keys of held and empties it.
+func (held *heldSet) take() []int {
+	held.mtx.Lock()
+	defer held.mtx.Unlock()
+	var keys []int
+	for k := range held.keys {
+		keys = append(keys, k)
+	}
+	held.keys = make(map[int]bool)
+	return keys
+}
+
+// HandleErrors sets f to be called with the errors of actions running in
+// the background, such as those triggered by do. The failing action is
+// aborted with the keys it held down released, the others keep running.
+// Errors are discarded if f is nil, which is the default.
+func (e *Engine) HandleErrors(f func(error)) {
+	e.errMtx.Lock()
+	defer e.errMtx.Unlock()
+	e.errHandler = f
+}
+
+func (e *Engine) reportError(err error) {
+	e.errMtx.Lock()
+	f := e.errHandler
+	e.errMtx.Unlock()
+	if f != nil {
+		f(err)
+	}
+}
+
+func (e *Engine) notifyOnSub(sub *subscriber, inputs []Input) func() {
+	e.notifyMtx.Lock()
+	defer e.notifyMtx.Unlock()
+	for _, v := range inputs {
+		k := v.asMapKey()
+		e.notifyOn[k] = append(e.notifyOn[k], sub)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			e.notifyMtx.Lock()
+			defer e.notifyMtx.Unlock()
+			for _, v := range inputs {
+				k := v.asMapKey()
+				e.notifyOn[k] = removeSubscriber(e.notifyOn[k], sub)
+				if len(e.notifyOn[k]) == 0 {
+					delete(e.notifyOn, k)
+				}
+			}
+			close(sub.stop)
+		})
+	}
+}
+
+func (e *Engine) notifySub(sub *subscriber) func() {
+	e.notifyMtx.Lock()
+	defer e.notifyMtx.Unlock()
+	e.notify = append(e.notify, sub)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			e.notifyMtx.Lock()
+			defer e.notifyMtx.Unlock()
+			e.notify = removeSubscriber(e.notify, sub)
+			close(sub.stop)
+		})
+	}
+}
+
+// Notify sends the input on ch whenever an input is detected.
+// Inputs are dropped if ch is not ready to receive.
+// Calling cancel stops the notifications, ch is not closed.
+func (e *Engine) Notify(ch chan<- Input) (cancel func()) {
+	return e.notifySub(newSubscriber(ch, false))
+}
+
+// NotifyOn sends the input on ch whenever any of the inputs is dectected.
+// Inputs are dropped if ch is not ready to receive.
+// Calling cancel stops the notifications, ch is not closed.
+func (e *Engine) NotifyOn(ch chan<- Input, inputs ...Input) (cancel func()) {
+	return e.notifyOnSub(newSubscriber(ch, false), inputs)
+}
+
+// NotifyBlocking is Notify but waits for ch to receive instead of
+// dropping inputs. Every subscriber waits along with it, so ch should
+// be buffered or received from promptly.
+func (e *Engine) NotifyBlocking(ch chan<- Input) (cancel func()) {
+	return e.notifySub(newSubscriber(ch, true))
+}
+
+// NotifyOnBlocking is NotifyOn but waits for ch to receive instead of
+// dropping inputs. Every subscriber waits along with it, so ch should
+// be buffered or received from promptly.
+func (e *Engine) NotifyOnBlocking(ch chan<- Input, inputs ...Input) (cancel func()) {
+	return e.notifyOnSub(newSubscriber(ch, true), inputs)
+}
@@ -0,0 +1,110 @@
+package autokey
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrKeyNotString = errors.New("key must be a string")
+	ErrInvalidKey   = errors.New("invalid key")
+	ErrDuplicateKey = errors.New("duplicate key")
+	ErrMissingKey   = errors.New("missing key")
+)
+
+// CompileError is an error in the structure or static values of a config.
+type CompileError struct {
+	File   string   // Source file, empty if not compiled from a file
+	Line   int      // Line of the offending node, 0 if unknown
+	Column int      // Column of the offending node, 0 if unknown
+	Path   []string // Keys and sequence indices leading to the mapping or sequence containing Key
+	Key    string   // Key of the offending entry, empty if the error is not about one
+	Err    error    // The cause
+}
+
+func (e *CompileError) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		fmt.Fprintf(&b, "%v:", e.File)
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&b, "%v:%v:", e.Line, e.Column)
+	}
+	if b.Len() > 0 {
+		b.WriteString(" ")
+	}
+
+	path := e.Path
+	if e.Key != "" {
+		path = append(path[:len(path):len(path)], e.Key)
+	}
+	if len(path) > 0 {
+		fmt.Fprintf(&b, "%v: ", strings.Join(path, "."))
+	}
+
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *CompileError) Unwrap() error {
+	return e.Err
+}
+
+// CompileErrors is every error found by a single call to Compile.
+type CompileErrors []*CompileError
+
+func (es CompileErrors) Error() string {
+	var lines []string
+	for _, e := range es {
+		lines = append(lines, e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Is reports whether any of the errors matches target.
+func (es CompileErrors) Is(target error) bool {
+	for _, e := range es {
+		if errors.Is(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors that matches target.
+func (es CompileErrors) As(target interface{}) bool {
+	for _, e := range es {
+		if errors.As(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// RuntimeError is an error in a value only known when a config runs.
+// The action it happened in is aborted.
+type RuntimeError struct {
+	Key   string      // Action or description the value is for
+	Value interface{} // The offending value
+	Err   error       // The cause
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("bad value for %v: %v: %v", e.Key, e.Value, e.Err)
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// descError is an error in the value of the description key.
+// It lets new*Expr functions tell which description is at fault.
+type descError struct {
+	key string
+	err error
+}
+
+func (e descError) Error() string {
+	return e.err.Error()
+}
@@ -29,6 +29,9 @@ func makeInputMap() map[string]Input {
 	str := []string{
 		"LeftClick",
 		"RightClick",
+		"MiddleClick",
+		"BackClick",
+		"ForwardClick",
 		"F1",
 		"F2",
 		"F3",
@@ -42,6 +45,8 @@ func makeInputMap() map[string]Input {
 		"F11",
 		"F12",
 		"Alt",
+		"LeftAlt",
+		"RightAlt",
 		"Ctrl",
 		"LeftCtrl",
 		"RightCtrl",
@@ -49,6 +54,8 @@ func makeInputMap() map[string]Input {
 		"LeftShift",
 		"RightShift",
 		"Enter",
+		"Tab",
+		"CapsLock",
 		"Esc",
 		"Space",
 		"Left",
@@ -68,10 +75,25 @@ func makeInputMap() map[string]Input {
 		"Num7",
 		"Num8",
 		"Num9",
+		"Semicolon",
+		"Equal",
+		"Comma",
+		"Minus",
+		"Period",
+		"Slash",
+		"Grave",
+		"LeftBracket",
+		"Backslash",
+		"RightBracket",
+		"Quote",
+		"IntlBackslash",
 	}
 	val := []int{
 		LeftClick,
 		RightClick,
+		MiddleClick,
+		BackClick,
+		ForwardClick,
 		F1,
 		F2,
 		F3,
@@ -85,6 +107,8 @@ func makeInputMap() map[string]Input {
 		F11,
 		F12,
 		Alt,
+		LeftAlt,
+		RightAlt,
 		Ctrl,
 		LeftCtrl,
 		RightCtrl,
@@ -92,6 +116,8 @@ func makeInputMap() map[string]Input {
 		LeftShift,
 		RightShift,
 		Enter,
+		Tab,
+		CapsLock,
 		Esc,
 		Space,
 		Left,
@@ -111,6 +137,18 @@ func makeInputMap() map[string]Input {
 		Num7,
 		Num8,
 		Num9,
+		Semicolon,
+		Equal,
+		Comma,
+		Minus,
+		Period,
+		Slash,
+		Grave,
+		LeftBracket,
+		Backslash,
+		RightBracket,
+		Quote,
+		IntlBackslash,
 	}
 	for i := range str {
 		m[varToColloquial(str[i])] = Input{Key: val[i]}
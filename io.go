@@ -1,8 +1,7 @@
 package autokey
 
 import (
-	"strings"
-	"sync"
+	"errors"
 
 	"github.com/Sinacam/autokey/sys"
 )
@@ -12,176 +11,183 @@ const (
 	KeyUp   = sys.KeyUp
 )
 
+// Mouse is the key of inputs moving the cursor or turning the wheel,
+// as specified by the flags MoveTo, MoveBy and Scroll.
 const (
-	LeftClick  = sys.LeftMouse
-	RightClick = sys.RightMouse
-	F1         = sys.F1
-	F2         = sys.F2
-	F3         = sys.F3
-	F4         = sys.F4
-	F5         = sys.F5
-	F6         = sys.F6
-	F7         = sys.F7
-	F8         = sys.F8
-	F9         = sys.F9
-	F10        = sys.F10
-	F11        = sys.F11
-	F12        = sys.F12
-	Alt        = sys.Alt
-	Ctrl       = sys.Ctrl
-	LeftCtrl   = sys.LeftCtrl
-	RightCtrl  = sys.RightCtrl
-	Shift      = sys.Shift
-	LeftShift  = sys.LeftShift
-	RightShift = sys.RightShift
-	Enter      = sys.Enter
-	Esc        = sys.Esc
-	Space      = sys.Space
-	Left       = sys.Left
-	Up         = sys.Up
-	Right      = sys.Right
-	Down       = sys.Down
-	End        = sys.End
-	Home       = sys.Home
-	Delete     = sys.Delete
-	Num0       = sys.Num0
-	Num1       = sys.Num1
-	Num2       = sys.Num2
-	Num3       = sys.Num3
-	Num4       = sys.Num4
-	Num5       = sys.Num5
-	Num6       = sys.Num6
-	Num7       = sys.Num7
-	Num8       = sys.Num8
-	Num9       = sys.Num9
+	Mouse  = sys.Mouse
+	MoveTo = sys.MoveTo
+	MoveBy = sys.MoveBy
+	Scroll = sys.Scroll
+)
+
+const (
+	LeftClick    = sys.LeftMouse
+	RightClick   = sys.RightMouse
+	MiddleClick  = sys.MiddleMouse
+	BackClick    = sys.BackMouse
+	ForwardClick = sys.ForwardMouse
+	F1           = sys.F1
+	F2           = sys.F2
+	F3           = sys.F3
+	F4           = sys.F4
+	F5           = sys.F5
+	F6           = sys.F6
+	F7           = sys.F7
+	F8           = sys.F8
+	F9           = sys.F9
+	F10          = sys.F10
+	F11          = sys.F11
+	F12          = sys.F12
+	Alt          = sys.Alt
+	LeftAlt      = sys.LeftAlt
+	RightAlt     = sys.RightAlt
+	Ctrl         = sys.Ctrl
+	LeftCtrl     = sys.LeftCtrl
+	RightCtrl    = sys.RightCtrl
+	Shift        = sys.Shift
+	LeftShift    = sys.LeftShift
+	RightShift   = sys.RightShift
+	Enter        = sys.Enter
+	Tab          = sys.Tab
+	CapsLock     = sys.CapsLock
+	Esc          = sys.Esc
+	Space        = sys.Space
+	Left         = sys.Left
+	Up           = sys.Up
+	Right        = sys.Right
+	Down         = sys.Down
+	End          = sys.End
+	Home         = sys.Home
+	Delete       = sys.Delete
+	Num0         = sys.Num0
+	Num1         = sys.Num1
+	Num2         = sys.Num2
+	Num3         = sys.Num3
+	Num4         = sys.Num4
+	Num5         = sys.Num5
+	Num6         = sys.Num6
+	Num7         = sys.Num7
+	Num8         = sys.Num8
+	Num9         = sys.Num9
+
+	Semicolon     = sys.Semicolon
+	Equal         = sys.Equal
+	Comma         = sys.Comma
+	Minus         = sys.Minus
+	Period        = sys.Period
+	Slash         = sys.Slash
+	Grave         = sys.Grave
+	LeftBracket   = sys.LeftBracket
+	Backslash     = sys.Backslash
+	RightBracket  = sys.RightBracket
+	Quote         = sys.Quote
+	IntlBackslash = sys.IntlBackslash
 )
 
 var (
-	im = newinputMonitor()
+	defaultEngine = NewEngine()
 
 	InvalidFlag = sys.InvalidFlag
+
+	// ErrBlockUnsupported is reported when blocking inputs is not
+	// supported by the backend, see sys.Blocker.
+	ErrBlockUnsupported = sys.ErrBlockUnsupported
+
+	// ErrNotInitialized is returned by Send and Eval prior to Init.
+	ErrNotInitialized = errors.New("autokey is not initialized")
 )
 
 type Input struct {
 	Key  int
 	Flag uint64
+
+	// X and Y are the position of the cursor for MoveTo, the motion for
+	// MoveBy and where the cursor was for monitored mouse inputs when
+	// reported by the backend. Delta is the number of notches for Scroll,
+	// positive away from the user.
+	X, Y  int
+	Delta int
+
+	// Injected is set on monitored inputs made by software, including
+	// those sent by autokey. It is ignored by Send.
+	Injected bool
 }
 
+// asMapKey identifies the key and flag of input, regardless of Injected
+// and the position and delta.
 func (input Input) asMapKey() uint64 {
 	return uint64(input.Key)<<32 | input.Flag
 }
 
-// Keys is a convenience function converting an alphanumeric string
-// to a slice of Inputs corresponding to those characters.
-// Non-alphanumeric characters are treated as their byte values.
+// Keys is a convenience function converting a string to the Inputs
+// typing it on a US layout, including shift where needed.
+// Characters not on the layout are left out.
 func Keys(s string) []Input {
 	var ret []Input
-	for _, v := range []byte(strings.ToUpper(s)) {
-		ret = append(ret, Input{Key: int(v)})
+	for _, r := range s {
+		if st, ok := layouts["us"][r]; ok {
+			ret = append(ret, st.inputs()...)
+		}
 	}
 	return ret
 }
 
-type inputMonitor struct {
-	hookChan chan uintptr
-	done     chan struct{}
-
-	notifyOn  map[uint64][]chan<- Input
-	notify    []chan<- Input
-	notifyMtx sync.RWMutex
-}
-
-func newinputMonitor() *inputMonitor {
-	return &inputMonitor{
-		notifyOn: make(map[uint64][]chan<- Input),
-		done:     make(chan struct{}),
-	}
-}
-
-func (im *inputMonitor) Init() {
-	go sys.SetGlobalHook()
-	go func() {
-		for {
-			k, f := sys.GetInput()
-
-			select {
-			case <-im.done:
-				return
-			default:
-			}
-
-			// GetInput may return k == 0 right after initialization and after teardown
-			// as means to unblock.
-			if k == 0 {
-				continue
-			}
-
-			input := Input{Key: k, Flag: f}
-			im.dispatch(input)
-		}
-	}()
+// Init must be called prior to Notify, NotifyOn, Send and Eval.
+// It uses the default backend of the platform.
+func Init() error {
+	return defaultEngine.Init()
 }
 
-func (im *inputMonitor) dispatch(input Input) {
-	im.notifyMtx.RLock()
-	defer im.notifyMtx.RUnlock()
-
-	for _, ch := range im.notify {
-		select {
-		case ch <- input:
-		default:
-		}
-	}
-	for _, ch := range im.notifyOn[input.asMapKey()] {
-		select {
-		case ch <- input:
-		default:
-		}
-	}
+// InitBackend is Init with an explicit backend.
+// Teardown does not close backend.
+func InitBackend(backend sys.Backend) {
+	defaultEngine.InitBackend(backend)
 }
 
-func (im *inputMonitor) Teardown() {
-	sys.Unhook()
-
-	im.notifyOn = make(map[uint64][]chan<- Input)
-	im.notify = nil
+// Teardown must be called after a call to Init.
+func Teardown() {
+	defaultEngine.Teardown()
 }
 
-func (im *inputMonitor) NotifyOn(ch chan<- Input, inputs []Input) {
-	im.notifyMtx.Lock()
-	defer im.notifyMtx.Unlock()
-	for _, v := range inputs {
-		k := v.asMapKey()
-		im.notifyOn[k] = append(im.notifyOn[k], ch)
-	}
+// Notify sends the input on ch whenever an input is detected.
+// Inputs are dropped if ch is not ready to receive.
+// Calling cancel stops the notifications, ch is not closed.
+func Notify(ch chan<- Input) (cancel func()) {
+	return defaultEngine.Notify(ch)
 }
 
-func (im *inputMonitor) Notify(ch chan<- Input) {
-	im.notifyMtx.Lock()
-	defer im.notifyMtx.Unlock()
-	im.notify = append(im.notify, ch)
+// NotifyOn sends the input on ch whenever any of the inputs is dectected.
+// Inputs are dropped if ch is not ready to receive.
+// Calling cancel stops the notifications, ch is not closed.
+func NotifyOn(ch chan<- Input, inputs ...Input) (cancel func()) {
+	return defaultEngine.NotifyOn(ch, inputs...)
 }
 
-// Init must be called prior to Notify and NotifyOn.
-func Init() {
-	im.Init()
+// NotifyBlocking is Notify but waits for ch to receive instead of
+// dropping inputs.
+func NotifyBlocking(ch chan<- Input) (cancel func()) {
+	return defaultEngine.NotifyBlocking(ch)
 }
 
-// Teardown must be called after a call to Init.
-func Teardown() {
-	im.Teardown()
+// NotifyOnBlocking is NotifyOn but waits for ch to receive instead of
+// dropping inputs.
+func NotifyOnBlocking(ch chan<- Input, inputs ...Input) (cancel func()) {
+	return defaultEngine.NotifyOnBlocking(ch, inputs...)
 }
 
-// Notify sends the input on ch whenever an input is detected.
-func Notify(ch chan<- Input) {
-	im.Notify(ch)
+// Dropped returns the number of inputs lost so far, either in the backend
+// before reaching autokey or when a subscriber was not ready to receive.
+func Dropped() (hook, dispatch uint64) {
+	return defaultEngine.Dropped()
 }
 
-// NotifyOn sends the input on ch whenever any of the inputs is dectected.
-func NotifyOn(ch chan<- Input, inputs ...Input) {
-	im.NotifyOn(ch, inputs)
+// HandleErrors sets f to be called with the errors of actions running in
+// the background, such as those triggered by do.
+func HandleErrors(f func(error)) {
+	defaultEngine.HandleErrors(f)
 }
 
+// Send sends input through the backend selected by Init.
 func Send(input Input) error {
-	return sys.Send(input.Key, input.Flag)
+	return defaultEngine.Send(input)
 }
@@ -0,0 +1,127 @@
+package autokey
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// stroke is how a character is typed on a layout.
+type stroke struct {
+	key   int
+	shift bool
+	altGr bool
+	dead  bool // a dead key, followed by space to type it on its own
+}
+
+// layout maps characters to the strokes typing them.
+type layout map[rune]stroke
+
+var (
+	// layoutRows are the keys of a keyboard in rows, named by what they
+	// type on a US layout. Keys are where they are on every layout.
+	layoutRows = [][]int{
+		{Grave, '1', '2', '3', '4', '5', '6', '7', '8', '9', '0', Minus, Equal},
+		{'Q', 'W', 'E', 'R', 'T', 'Y', 'U', 'I', 'O', 'P', LeftBracket, RightBracket, Backslash},
+		{'A', 'S', 'D', 'F', 'G', 'H', 'J', 'K', 'L', Semicolon, Quote},
+		{IntlBackslash, 'Z', 'X', 'C', 'V', 'B', 'N', 'M', Comma, Period, Slash},
+	}
+
+	layouts = map[string]layout{
+		"us": makeLayout(layoutLevels{
+			plain: []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", " zxcvbnm,./"},
+			shift: []string{"~!@#$%^&*()_+", "QWERTYUIOP{}|", "ASDFGHJKL:\"", " ZXCVBNM<>?"},
+		}),
+		"uk": makeLayout(layoutLevels{
+			plain: []string{"`1234567890-=", "qwertyuiop[]#", "asdfghjkl;'", "\\zxcvbnm,./"},
+			shift: []string{"¬!\"£$%^&*()_+", "QWERTYUIOP{}~", "ASDFGHJKL:@", "|ZXCVBNM<>?"},
+			altGr: []string{"¦   €        ", "  é    úíó   ", "á          ", "           "},
+		}),
+		"de": makeLayout(layoutLevels{
+			plain: []string{"^1234567890ß´", "qwertzuiopü+#", "asdfghjklöä", "<yxcvbnm,.-"},
+			shift: []string{"°!\"§$%&/()=?`", "QWERTZUIOPÜ*'", "ASDFGHJKLÖÄ", ">YXCVBNM;:_"},
+			altGr: []string{"  ²³   {[]}\\ ", "@ €        ~ ", "           ", "|      µ   "},
+			dead:  "^´`",
+		}),
+	}
+)
+
+// layoutLevels are the characters typed by the keys of layoutRows
+// with no modifier, shift or AltGr, a space where none is typed.
+type layoutLevels struct {
+	plain, shift, altGr []string
+	dead                string
+}
+
+// makeLayout makes the layout typing levels, along with the whitespace
+// typed by the same keys on every layout.
+func makeLayout(levels layoutLevels) layout {
+	l := layout{
+		' ':  {key: Space},
+		'\t': {key: Tab},
+		'\n': {key: Enter},
+	}
+	add := func(rows []string, s stroke) {
+		for i, row := range rows {
+			for j, r := range []rune(row) {
+				if r == ' ' {
+					continue
+				}
+				// A character typed by more than one stroke is typed by
+				// the first, with the fewest modifiers.
+				if _, has := l[r]; has {
+					continue
+				}
+				s.key = layoutRows[i][j]
+				s.dead = strings.ContainsRune(levels.dead, r)
+				l[r] = s
+			}
+		}
+	}
+	add(levels.plain, stroke{})
+	add(levels.shift, stroke{shift: true})
+	add(levels.altGr, stroke{altGr: true})
+	return l
+}
+
+// inputs returns the inputs typing s.
+func (s stroke) inputs() []Input {
+	var mods []int
+	if s.shift {
+		mods = append(mods, LeftShift)
+	}
+	if s.altGr {
+		mods = append(mods, RightAlt)
+	}
+
+	var ret []Input
+	for _, k := range mods {
+		ret = append(ret, Input{Key: k, Flag: KeyDown})
+	}
+	ret = append(ret, Input{Key: s.key, Flag: KeyDown}, Input{Key: s.key, Flag: KeyUp})
+	for i := range mods {
+		ret = append(ret, Input{Key: mods[len(mods)-1-i], Flag: KeyUp})
+	}
+	if s.dead {
+		ret = append(ret, Input{Key: Space, Flag: KeyDown}, Input{Key: Space, Flag: KeyUp})
+	}
+	return ret
+}
+
+// parseLayout parses val as the name of a layout.
+func parseLayout(val interface{}) (layout, error) {
+	s, ok := val.(string)
+	if !ok {
+		return nil, fmt.Errorf("cannot parse %v as layout", val)
+	}
+	l, ok := layouts[strings.ToLower(s)]
+	if !ok {
+		var names []string
+		for k := range layouts {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown layout %v, expected one of %v", s, strings.Join(names, ", "))
+	}
+	return l, nil
+}
@@ -0,0 +1,132 @@
+package sys
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	InvalidFlag = errors.New("invalid flag")
+	InvalidKey  = errors.New("invalid key")
+
+	// ErrBlockUnsupported is returned when a backend cannot block inputs.
+	ErrBlockUnsupported = errors.New("backend cannot block inputs")
+
+	// ErrUnsupported is returned by Send for inputs a backend cannot make.
+	ErrUnsupported = errors.New("input not supported by backend")
+
+	// ErrNoBackend is returned by Open when no backend is available.
+	ErrNoBackend = errors.New("no input backend available on this platform")
+
+	// ErrBackendInUse is returned by Open for a backend that can only be
+	// open once at a time, until it is unhooked.
+	ErrBackendInUse = errors.New("input backend is already in use")
+)
+
+// Event is a single input as seen by a Backend.
+type Event struct {
+	Key  int
+	Flag uint64
+
+	// Amounts of Mouse inputs, see MoveTo, MoveBy and Scroll.
+	// Backends that know where the cursor is also set X and Y on
+	// mouse buttons.
+	X, Y  int
+	Delta int
+
+	// Injected is set on inputs made by software rather than a physical
+	// device, such as those sent through Send. Backends that cannot tell
+	// apart inputs of other software report only their own.
+	Injected bool
+}
+
+// Backend is a source and sink of system-wide inputs.
+type Backend interface {
+	// Send emits e as if it came from a physical device.
+	Send(e Event) error
+
+	// SetGlobalHook installs the global input hook and blocks until Unhook.
+	SetGlobalHook() error
+
+	// GetInput blocks until the hook receives an input.
+	// Inputs sent through Send are received too, with Injected set.
+	// It may return an Event with Key == 0 right after initialization and
+	// after Unhook as means to unblock.
+	GetInput() Event
+
+	// Unhook uninstalls the global input hook.
+	Unhook()
+}
+
+// DropCounter is implemented by backends whose hook drops inputs
+// when they are not taken by GetInput quickly enough.
+type DropCounter interface {
+	// Dropped returns the number of inputs dropped so far.
+	Dropped() uint64
+}
+
+// Blocker is implemented by backends able to keep physical inputs from
+// reaching other programs. They are still received by GetInput.
+type Blocker interface {
+	// Block consumes the inputs of key until a matching Unblock.
+	// Calls nest, a key stays blocked until unblocked as many times.
+	Block(key int) error
+
+	// Unblock undoes one call to Block.
+	Unblock(key int)
+}
+
+// Cursor is implemented by backends able to tell where the mouse cursor
+// is, which is where MoveTo is relative to.
+type Cursor interface {
+	// Cursor returns the position of the cursor on screen.
+	Cursor() (x, y int, err error)
+}
+
+// Unicoder is implemented by backends able to type characters directly,
+// regardless of the keys and layout of the keyboard.
+type Unicoder interface {
+	// SendUnicode types r as if pressed and released on a keyboard.
+	SendUnicode(r rune) error
+}
+
+var (
+	backends    = make(map[string]func() (Backend, error))
+	defaultName string
+	backendsMtx sync.Mutex
+)
+
+// Register makes a backend available to Open by name.
+// Registering the same name twice replaces the previous backend.
+func Register(name string, open func() (Backend, error)) {
+	backendsMtx.Lock()
+	defer backendsMtx.Unlock()
+	backends[name] = open
+}
+
+// setDefault marks name as the backend Open uses for an empty name.
+func setDefault(name string) {
+	backendsMtx.Lock()
+	defer backendsMtx.Unlock()
+	defaultName = name
+}
+
+// Open opens the backend registered as name.
+// An empty name opens the platform's default backend.
+func Open(name string) (Backend, error) {
+	backendsMtx.Lock()
+	if name == "" {
+		name = defaultName
+	}
+	open, ok := backends[name]
+	backendsMtx.Unlock()
+
+	if name == "" {
+		return nil, ErrNoBackend
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown input backend %v", name)
+	}
+	return open()
+}
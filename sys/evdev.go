@@ -0,0 +1,330 @@
+package sys
+
+import (
+	"encoding/binary"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Linux input event types and codes from linux/input-event-codes.h.
+// The encoding is kept free of build tags so it can be exercised with
+// plain files and pipes on any platform.
+const (
+	evSyn = 0x00
+	evKey = 0x01
+	evRel = 0x02
+	evAbs = 0x03
+	evMsc = 0x04
+
+	synReport = 0
+
+	relX           = 0x00
+	relY           = 0x01
+	relHWheel      = 0x06
+	relWheel       = 0x08
+	relWheelHiRes  = 0x0b
+	relHWheelHiRes = 0x0c
+
+	mscScan = 0x04
+
+	// wheelHiRes is a wheel notch in REL_WHEEL_HI_RES units.
+	wheelHiRes = 120
+
+	btnLeft   = 0x110
+	btnRight  = 0x111
+	btnMiddle = 0x112
+	btnSide   = 0x113
+	btnExtra  = 0x114
+	btnTask   = 0x117
+
+	// keyMax bounds the keyboard codes a virtual device announces.
+	keyMax = 0xff
+
+	// keyOk through keyExtMax are the keyboard codes past the buttons,
+	// stopping short of the joystick buttons that follow.
+	keyOk     = 0x160
+	keyExtMax = 0x2bf
+)
+
+var (
+	// linuxCodes maps autokey's key constants to Linux KEY_* and BTN_* codes.
+	linuxCodes = makeLinuxCodes()
+
+	// autokeyCodes is the inverse of linuxCodes.
+	// Codes shared by generic and sided modifiers map to the sided
+	// constant, same as what low level hooks report on Windows.
+	autokeyCodes = makeAutokeyCodes()
+)
+
+func makeLinuxCodes() map[int]uint16 {
+	m := map[int]uint16{
+		LeftMouse:    btnLeft,
+		RightMouse:   btnRight,
+		MiddleMouse:  btnMiddle,
+		BackMouse:    btnSide,
+		ForwardMouse: btnExtra,
+
+		Alt:        56,  // KEY_LEFTALT
+		LeftAlt:    56,  // KEY_LEFTALT
+		RightAlt:   100, // KEY_RIGHTALT
+		Ctrl:       29,  // KEY_LEFTCTRL
+		LeftCtrl:   29,  // KEY_LEFTCTRL
+		RightCtrl:  97,  // KEY_RIGHTCTRL
+		Shift:      42,  // KEY_LEFTSHIFT
+		LeftShift:  42,  // KEY_LEFTSHIFT
+		RightShift: 54,  // KEY_RIGHTSHIFT
+		Enter:      28,  // KEY_ENTER
+		Tab:        15,  // KEY_TAB
+		CapsLock:   58,  // KEY_CAPSLOCK
+		Esc:        1,   // KEY_ESC
+		Space:      57,  // KEY_SPACE
+		Left:       105, // KEY_LEFT
+		Up:         103, // KEY_UP
+		Right:      106, // KEY_RIGHT
+		Down:       108, // KEY_DOWN
+		End:        107, // KEY_END
+		Home:       102, // KEY_HOME
+		Delete:     111, // KEY_DELETE
+
+		Num0: 82, // KEY_KP0
+		Num1: 79,
+		Num2: 80,
+		Num3: 81,
+		Num4: 75,
+		Num5: 76,
+		Num6: 77,
+		Num7: 71,
+		Num8: 72,
+		Num9: 73,
+
+		F11: 87,
+		F12: 88,
+
+		Semicolon:     39, // KEY_SEMICOLON
+		Equal:         13, // KEY_EQUAL
+		Comma:         51, // KEY_COMMA
+		Minus:         12, // KEY_MINUS
+		Period:        52, // KEY_DOT
+		Slash:         53, // KEY_SLASH
+		Grave:         41, // KEY_GRAVE
+		LeftBracket:   26, // KEY_LEFTBRACE
+		Backslash:     43, // KEY_BACKSLASH
+		RightBracket:  27, // KEY_RIGHTBRACE
+		Quote:         40, // KEY_APOSTROPHE
+		IntlBackslash: 86, // KEY_102ND
+	}
+
+	// KEY_1 through KEY_0 are contiguous, with 0 last.
+	for c := '1'; c <= '9'; c++ {
+		m[int(c)] = uint16(c-'1') + 2
+	}
+	m['0'] = 11
+
+	// Letters follow the QWERTY rows rather than the alphabet.
+	rows := []struct {
+		keys  string
+		first uint16
+	}{
+		{"QWERTYUIOP", 16},
+		{"ASDFGHJKL", 30},
+		{"ZXCVBNM", 44},
+	}
+	for _, row := range rows {
+		for i, c := range row.keys {
+			m[int(c)] = row.first + uint16(i)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		m[F1+i] = 59 + uint16(i) // KEY_F1 ~ KEY_F10
+	}
+	for i := 0; i < 12; i++ {
+		m[F13+i] = 183 + uint16(i) // KEY_F13 ~ KEY_F24
+	}
+	return m
+}
+
+func makeAutokeyCodes() map[uint16]int {
+	m := make(map[uint16]int)
+	for k, code := range linuxCodes {
+		switch k {
+		case Alt, Ctrl, Shift:
+			continue
+		}
+		m[code] = k
+	}
+	return m
+}
+
+// inputEvent mirrors struct input_event.
+// The timeval fields are C longs, which are as wide as a Go int on Linux.
+type inputEvent struct {
+	Sec   int64
+	Usec  int64
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+var (
+	longSize       = strconv.IntSize / 8
+	inputEventSize = 2*longSize + 8
+)
+
+func (ie inputEvent) appendTo(buf []byte) []byte {
+	b := make([]byte, inputEventSize)
+	putLong(b, ie.Sec)
+	putLong(b[longSize:], ie.Usec)
+	binary.LittleEndian.PutUint16(b[2*longSize:], ie.Type)
+	binary.LittleEndian.PutUint16(b[2*longSize+2:], ie.Code)
+	binary.LittleEndian.PutUint32(b[2*longSize+4:], uint32(ie.Value))
+	return append(buf, b...)
+}
+
+func putLong(b []byte, v int64) {
+	if longSize == 8 {
+		binary.LittleEndian.PutUint64(b, uint64(v))
+	} else {
+		binary.LittleEndian.PutUint32(b, uint32(v))
+	}
+}
+
+func getLong(b []byte) int64 {
+	if longSize == 8 {
+		return int64(binary.LittleEndian.Uint64(b))
+	}
+	return int64(int32(binary.LittleEndian.Uint32(b)))
+}
+
+func decodeInputEvent(b []byte) inputEvent {
+	return inputEvent{
+		Sec:   getLong(b),
+		Usec:  getLong(b[longSize:]),
+		Type:  binary.LittleEndian.Uint16(b[2*longSize:]),
+		Code:  binary.LittleEndian.Uint16(b[2*longSize+2:]),
+		Value: int32(binary.LittleEndian.Uint32(b[2*longSize+4:])),
+	}
+}
+
+// eventReader decodes input_event records into Events.
+// r is normally an evdev device, but any recorded stream works.
+type eventReader struct {
+	r   io.Reader
+	buf []byte
+}
+
+func newEventReader(r io.Reader) *eventReader {
+	return &eventReader{r: r, buf: make([]byte, inputEventSize)}
+}
+
+// readRaw reads the next input_event record.
+func (er *eventReader) readRaw() (inputEvent, error) {
+	_, err := io.ReadFull(er.r, er.buf)
+	if err != nil {
+		return inputEvent{}, err
+	}
+	return decodeInputEvent(er.buf), nil
+}
+
+// next returns the next key or button as an Event.
+// Records without an autokey equivalent are skipped.
+// Auto-repeats are reported as KeyDown, same as on Windows.
+func (er *eventReader) next() (Event, error) {
+	for {
+		ie, err := er.readRaw()
+		if err != nil {
+			return Event{}, err
+		}
+
+		if e, ok := toEvent(ie); ok {
+			return e, nil
+		}
+	}
+}
+
+// toEvent converts ie to an Event if it is a known key or button,
+// or a wheel motion. Mouse motion is not converted, it would crowd out
+// the other inputs.
+func toEvent(ie inputEvent) (Event, bool) {
+	if ie.Type == evRel && ie.Code == relWheel {
+		return Event{Key: Mouse, Flag: Scroll, Delta: int(ie.Value)}, true
+	}
+	if ie.Type != evKey {
+		return Event{}, false
+	}
+	k, ok := autokeyCodes[ie.Code]
+	if !ok {
+		return Event{}, false
+	}
+
+	switch ie.Value {
+	case 0:
+		return Event{Key: k, Flag: KeyUp}, true
+	case 1, 2:
+		return Event{Key: k, Flag: KeyDown}, true
+	}
+	return Event{}, false
+}
+
+// eventWriter encodes Events as input_event records.
+// w is normally a uinput device, but any file or pipe works.
+type eventWriter struct {
+	w io.Writer
+}
+
+// send writes e followed by a SYN_REPORT in a single write,
+// so concurrent sends never interleave within a report.
+// Relative devices cannot move to a position, MoveTo is unsupported.
+func (ew eventWriter) send(e Event) error {
+	now := time.Now()
+	sec, usec := now.Unix(), int64(now.Nanosecond()/1000)
+	syn := inputEvent{Sec: sec, Usec: usec, Type: evSyn, Code: synReport}
+
+	if e.Key == Mouse {
+		rel := func(code uint16, value int) inputEvent {
+			return inputEvent{Sec: sec, Usec: usec, Type: evRel, Code: code, Value: int32(value)}
+		}
+		switch e.Flag {
+		case MoveBy:
+			return ew.writeRaw([]inputEvent{rel(relX, e.X), rel(relY, e.Y), syn})
+		case Scroll:
+			// The high resolution event is required by readers that
+			// prefer it once the device announces it.
+			return ew.writeRaw([]inputEvent{rel(relWheel, e.Delta), rel(relWheelHiRes, e.Delta*wheelHiRes), syn})
+		case MoveTo:
+			return ErrUnsupported
+		}
+		return InvalidFlag
+	}
+
+	code, ok := linuxCodes[e.Key]
+	if !ok {
+		return InvalidKey
+	}
+
+	var value int32
+	switch e.Flag {
+	case KeyDown:
+		value = 1
+	case KeyUp:
+		value = 0
+	default:
+		return InvalidFlag
+	}
+
+	return ew.writeRaw([]inputEvent{
+		{Sec: sec, Usec: usec, Type: evKey, Code: code, Value: value},
+		syn,
+	})
+}
+
+// writeRaw writes ies in a single write.
+func (ew eventWriter) writeRaw(ies []inputEvent) error {
+	var buf []byte
+	for _, ie := range ies {
+		buf = ie.appendTo(buf)
+	}
+	_, err := ew.w.Write(buf)
+	return err
+}
@@ -0,0 +1,193 @@
+package sys
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+)
+
+const evdevGlob = "/dev/input/event*"
+
+// ioctl requests from linux/input.h.
+const eviocgrab = 0x40044590
+
+func eviocgname(n int) uintptr {
+	return 0x80000000 | uintptr(n)<<16 | 0x4506
+}
+
+func eviocgbit(ev, n int) uintptr {
+	return 0x80000000 | uintptr(n)<<16 | 0x4500 | uintptr(0x20+ev)
+}
+
+// fileIoctl is ioctl on f without switching f to blocking mode,
+// so that closing f still unblocks pending reads.
+func fileIoctl(f *os.File, req, arg uintptr) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var ierr error
+	err = rc.Control(func(fd uintptr) {
+		ierr = ioctl(fd, req, arg)
+	})
+	if err != nil {
+		return err
+	}
+	return ierr
+}
+
+// EvdevDevice describes an evdev input device.
+type EvdevDevice struct {
+	Path     string
+	Name     string
+	Keyboard bool // reports letter keys
+	Mouse    bool // reports a left button
+	Absolute bool // reports absolute axes, as touchpads and tablets do
+}
+
+// FindDevices lists the keyboards and mice under /dev/input.
+// Devices that cannot be opened are skipped.
+func FindDevices() ([]EvdevDevice, error) {
+	paths, err := filepath.Glob(evdevGlob)
+	if err != nil {
+		return nil, err
+	}
+
+	var devs []EvdevDevice
+	for _, path := range paths {
+		dev, err := queryDevice(path)
+		if err != nil {
+			continue
+		}
+		if dev.Keyboard || dev.Mouse {
+			devs = append(devs, dev)
+		}
+	}
+	return devs, nil
+}
+
+func queryDevice(path string) (EvdevDevice, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return EvdevDevice{}, err
+	}
+	defer f.Close()
+
+	name := make([]byte, 256)
+	err = fileIoctl(f, eviocgname(len(name)), uintptr(unsafe.Pointer(&name[0])))
+	if err != nil {
+		return EvdevDevice{}, err
+	}
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+
+	types := make([]byte, 0x20/8) // EV_MAX / 8 + 1
+	err = fileIoctl(f, eviocgbit(0, len(types)), uintptr(unsafe.Pointer(&types[0])))
+	if err != nil {
+		return EvdevDevice{}, err
+	}
+
+	keys := make([]byte, 0x300/8) // KEY_MAX / 8 + 1
+	err = fileIoctl(f, eviocgbit(evKey, len(keys)), uintptr(unsafe.Pointer(&keys[0])))
+	if err != nil {
+		return EvdevDevice{}, err
+	}
+	hasKey := func(code int) bool {
+		return keys[code/8]&(1<<uint(code%8)) != 0
+	}
+
+	return EvdevDevice{
+		Path:     path,
+		Name:     string(name),
+		Keyboard: hasKey(int(linuxCodes['A'])) && hasKey(int(linuxCodes[Space])),
+		Mouse:    hasKey(btnLeft),
+		Absolute: types[evAbs/8]&(1<<uint(evAbs%8)) != 0,
+	}, nil
+}
+
+// EvdevConfig selects the devices the Linux backend captures inputs from.
+type EvdevConfig struct {
+	// Paths of the devices to read. Keyboards and mice are found with
+	// FindDevices when empty.
+	Paths []string
+
+	// Name, if not empty, keeps only devices whose name contains it.
+	Name string
+
+	// Grab takes exclusive access of the devices. Their inputs are passed
+	// through the virtual device so other programs still receive them.
+	// Devices with absolute axes are not grabbed, since the virtual device
+	// only moves relatively, and their inputs cannot be blocked.
+	Grab bool
+}
+
+// devices resolves the devices selected by cfg.
+func (cfg EvdevConfig) devices() ([]EvdevDevice, error) {
+	var devs []EvdevDevice
+	if len(cfg.Paths) == 0 {
+		found, err := FindDevices()
+		if err != nil {
+			return nil, err
+		}
+		devs = found
+	} else {
+		for _, path := range cfg.Paths {
+			dev, err := queryDevice(path)
+			if err != nil {
+				return nil, err
+			}
+			devs = append(devs, dev)
+		}
+	}
+
+	var selected []EvdevDevice
+	for _, dev := range devs {
+		// Inputs sent through the virtual device are reported by Send,
+		// and passing it through would feed inputs back to itself.
+		if dev.Name == uinputName {
+			continue
+		}
+		if !strings.Contains(dev.Name, cfg.Name) {
+			continue
+		}
+		selected = append(selected, dev)
+	}
+
+	if len(selected) == 0 {
+		return nil, ErrNoDevice
+	}
+	return selected, nil
+}
+
+// evdevDevice is an opened evdev device.
+type evdevDevice struct {
+	f       *os.File
+	grabbed bool
+	*eventReader
+}
+
+func openEvdev(path string, grab bool) (*evdevDevice, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if grab {
+		err = fileIoctl(f, eviocgrab, 1)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return &evdevDevice{f: f, grabbed: grab, eventReader: newEventReader(f)}, nil
+}
+
+// Close also releases the grab.
+func (d *evdevDevice) Close() error {
+	return d.f.Close()
+}
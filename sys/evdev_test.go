@@ -0,0 +1,170 @@
+package sys
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// record encodes ies as a stream read from an evdev device.
+func record(ies ...inputEvent) *bytes.Reader {
+	var buf []byte
+	for _, ie := range ies {
+		buf = ie.appendTo(buf)
+	}
+	return bytes.NewReader(buf)
+}
+
+func TestEventReaderNext(t *testing.T) {
+	a := linuxCodes['A']
+	syn := inputEvent{Type: evSyn, Code: synReport}
+	r := newEventReader(record(
+		inputEvent{Type: evKey, Code: a, Value: 1},
+		inputEvent{Type: 0x04, Code: 0x04, Value: 30}, // EV_MSC MSC_SCAN
+		syn,
+		inputEvent{Type: evKey, Code: a, Value: 2},
+		syn,
+		inputEvent{Type: evKey, Code: a, Value: 0},
+		syn,
+		inputEvent{Type: evKey, Code: 0x2ff, Value: 1},
+		inputEvent{Type: evRel, Code: relX, Value: 5},
+		inputEvent{Type: evRel, Code: relWheel, Value: -1},
+		syn,
+		inputEvent{Type: evKey, Code: btnLeft, Value: 1},
+		syn,
+	))
+
+	want := []Event{
+		{Key: 'A', Flag: KeyDown},
+		{Key: 'A', Flag: KeyDown}, // auto-repeat
+		{Key: 'A', Flag: KeyUp},
+		{Key: Mouse, Flag: Scroll, Delta: -1},
+		{Key: LeftMouse, Flag: KeyDown},
+	}
+	for i, w := range want {
+		e, err := r.next()
+		if err != nil {
+			t.Fatalf("event %v: %v", i, err)
+		}
+		if e != w {
+			t.Errorf("event %v = %+v, want %+v", i, e, w)
+		}
+	}
+	if _, err := r.next(); err != io.EOF {
+		t.Errorf("after the last event, err = %v, want EOF", err)
+	}
+}
+
+func TestEventReaderTruncated(t *testing.T) {
+	var buf []byte
+	buf = inputEvent{Type: evKey, Code: linuxCodes['A'], Value: 1}.appendTo(buf)
+	r := newEventReader(bytes.NewReader(buf[:len(buf)-1]))
+	if _, err := r.next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("err = %v, want ErrUnexpectedEOF", err)
+	}
+}
+
+func TestModifiersDecodeToSides(t *testing.T) {
+	for _, k := range []int{Alt, Ctrl, Shift} {
+		e, ok := toEvent(inputEvent{Type: evKey, Code: linuxCodes[k], Value: 1})
+		if !ok {
+			t.Fatalf("key %v not decoded", k)
+		}
+		if e.Key == k {
+			t.Errorf("key %v decoded as generic, want its left side", k)
+		}
+	}
+}
+
+func TestEventWriterSend(t *testing.T) {
+	syn := inputEvent{Type: evSyn, Code: synReport}
+	tests := []struct {
+		e    Event
+		want []inputEvent
+	}{
+		{Event{Key: 'A', Flag: KeyDown}, []inputEvent{{Type: evKey, Code: 30, Value: 1}, syn}},
+		{Event{Key: 'A', Flag: KeyUp}, []inputEvent{{Type: evKey, Code: 30, Value: 0}, syn}},
+		{Event{Key: RightMouse, Flag: KeyDown}, []inputEvent{{Type: evKey, Code: btnRight, Value: 1}, syn}},
+		{Event{Key: Mouse, Flag: MoveBy, X: -3, Y: 4}, []inputEvent{
+			{Type: evRel, Code: relX, Value: -3},
+			{Type: evRel, Code: relY, Value: 4},
+			syn,
+		}},
+		{Event{Key: Mouse, Flag: Scroll, Delta: 2}, []inputEvent{{Type: evRel, Code: relWheel, Value: 2}, {Type: evRel, Code: relWheelHiRes, Value: 240}, syn}},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		err := eventWriter{w: &buf}.send(tt.e)
+		if err != nil {
+			t.Errorf("send(%+v): %v", tt.e, err)
+			continue
+		}
+
+		r := newEventReader(&buf)
+		for i, w := range tt.want {
+			ie, err := r.readRaw()
+			if err != nil {
+				t.Fatalf("send(%+v) record %v: %v", tt.e, i, err)
+			}
+			ie.Sec, ie.Usec = 0, 0
+			if ie != w {
+				t.Errorf("send(%+v) record %v = %+v, want %+v", tt.e, i, ie, w)
+			}
+		}
+		if buf.Len() != 0 {
+			t.Errorf("send(%+v) wrote %v extra bytes", tt.e, buf.Len())
+		}
+	}
+}
+
+func TestEventWriterErrors(t *testing.T) {
+	tests := []struct {
+		e    Event
+		want error
+	}{
+		{Event{Key: 'A', Flag: 0}, InvalidFlag},
+		{Event{Key: 0x07, Flag: KeyDown}, InvalidKey},
+		{Event{Key: Mouse, Flag: MoveTo}, ErrUnsupported},
+		{Event{Key: Mouse, Flag: KeyDown}, InvalidFlag},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		err := eventWriter{w: &buf}.send(tt.e)
+		if err != tt.want {
+			t.Errorf("send(%+v) = %v, want %v", tt.e, err, tt.want)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("send(%+v) wrote %v bytes on error", tt.e, buf.Len())
+		}
+	}
+}
+
+func TestEventWriterRoundTrip(t *testing.T) {
+	events := []Event{
+		{Key: 'Z', Flag: KeyDown},
+		{Key: Semicolon, Flag: KeyDown},
+		{Key: Semicolon, Flag: KeyUp},
+		{Key: 'Z', Flag: KeyUp},
+		{Key: Mouse, Flag: Scroll, Delta: -1},
+	}
+
+	var buf bytes.Buffer
+	for _, e := range events {
+		if err := (eventWriter{w: &buf}).send(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := newEventReader(&buf)
+	for _, want := range events {
+		e, err := r.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e != want {
+			t.Errorf("read %+v, want %+v", e, want)
+		}
+	}
+}
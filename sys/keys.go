@@ -0,0 +1,105 @@
+package sys
+
+const (
+	// Flags describing the direction of an input.
+	// Backends map these to their own representation internally.
+	KeyDown = iota + 1
+	KeyUp
+
+	// Mouse clicks are represented as keys above the keyboard key range.
+	LeftMouse = int(iota + 256)
+	RightMouse
+	MiddleMouse
+	BackMouse
+	ForwardMouse
+)
+
+const (
+	// Mouse is the key of mouse motion and wheel inputs.
+	Mouse = 256 + 1
+
+	// Flags of Mouse inputs, which carry their amounts in
+	// the X, Y and Delta of an Event.
+	MoveTo = KeyUp + iota // move the cursor to the position X, Y
+	MoveBy                // move the mouse by X, Y
+	Scroll                // scroll the wheel by Delta notches, positive is away from the user
+)
+
+// Keyboard keys are identified by their Windows virtual-key codes on every
+// platform. Backends for other platforms translate them to native codes.
+// Keys typing characters, the letters, digits and punctuation, are those
+// in the same place as on a US layout regardless of the active layout.
+const (
+	F1  = 0x70
+	F2  = 0x71
+	F3  = 0x72
+	F4  = 0x73
+	F5  = 0x74
+	F6  = 0x75
+	F7  = 0x76
+	F8  = 0x77
+	F9  = 0x78
+	F10 = 0x79
+	F11 = 0x7A
+	F12 = 0x7B
+	F13 = 0x7C
+	F14 = 0x7D
+	F15 = 0x7E
+	F16 = 0x7F
+	F17 = 0x80
+	F18 = 0x81
+	F19 = 0x82
+	F20 = 0x83
+	F21 = 0x84
+	F22 = 0x85
+	F23 = 0x86
+	F24 = 0x87
+
+	Alt        = 0x12
+	LeftAlt    = 0xA4
+	RightAlt   = 0xA5
+	Ctrl       = 0x11
+	LeftCtrl   = 0xA2
+	RightCtrl  = 0xA3
+	Shift      = 0x10
+	LeftShift  = 0xA0
+	RightShift = 0xA1
+	Enter      = 0x0D
+	Tab        = 0x09
+	CapsLock   = 0x14
+	Esc        = 0x1B
+	Space      = 0x20
+	Left       = 0x25
+	Up         = 0x26
+	Right      = 0x27
+	Down       = 0x28
+	End        = 0x23
+	Home       = 0x24
+	Delete     = 0x2E
+
+	Num0 = 0x60
+	Num1 = 0x61
+	Num2 = 0x62
+	Num3 = 0x63
+	Num4 = 0x64
+	Num5 = 0x65
+	Num6 = 0x66
+	Num7 = 0x67
+	Num8 = 0x68
+	Num9 = 0x69
+
+	// Punctuation keys are named by what they type on a US layout,
+	// IntlBackslash is the extra key next to left shift on ISO keyboards.
+	Semicolon     = 0xBA
+	Equal         = 0xBB
+	Comma         = 0xBC
+	Minus         = 0xBD
+	Period        = 0xBE
+	Slash         = 0xBF
+	Grave         = 0xC0
+	LeftBracket   = 0xDB
+	Backslash     = 0xDC
+	RightBracket  = 0xDD
+	Quote         = 0xDE
+	IntlBackslash = 0xE2
+)
@@ -0,0 +1,190 @@
+package sys
+
+import (
+	"sync"
+	"time"
+)
+
+// Record is an Event sent through a Sim along with when it was sent.
+type Record struct {
+	Event
+	Time time.Time
+}
+
+// Sim is an in-memory backend for deterministic testing.
+// Inputs are injected with Inject as if the global hook fired,
+// and every Event passed to Send is kept in a transcript.
+// A Sim stays unhooked after Unhook, use a new one instead.
+type Sim struct {
+	// Echo makes sent Events get injected with Injected set,
+	// like the global hook observing them. Set it before use.
+	Echo bool
+
+	mtx        sync.Mutex
+	cond       *sync.Cond
+	queue      []Event
+	unhooked   bool
+	transcript []Record
+	blocked    map[int]int
+	x, y       int
+	typed      []rune
+}
+
+// NewSim returns a Sim with an empty transcript.
+func NewSim() *Sim {
+	s := &Sim{}
+	s.cond = sync.NewCond(&s.mtx)
+	return s
+}
+
+// Inject queues events to be returned by GetInput in order.
+// Events injected before SetGlobalHook are kept, so tests need not
+// synchronize with the hook goroutine.
+func (s *Sim) Inject(events ...Event) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.queue = append(s.queue, events...)
+	s.cond.Broadcast()
+}
+
+// Transcript returns a copy of everything sent so far.
+func (s *Sim) Transcript() []Record {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]Record(nil), s.transcript...)
+}
+
+// WaitTranscript waits until at least n Events have been sent or timeout
+// elapses, then returns the transcript.
+func (s *Sim) WaitTranscript(n int, timeout time.Duration) []Record {
+	timer := time.AfterFunc(timeout, func() {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		s.cond.Broadcast()
+	})
+	defer timer.Stop()
+
+	deadline := time.Now().Add(timeout)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for len(s.transcript) < n && time.Now().Before(deadline) {
+		s.cond.Wait()
+	}
+	return append([]Record(nil), s.transcript...)
+}
+
+// Reset clears the transcript.
+func (s *Sim) Reset() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.transcript = nil
+}
+
+func (s *Sim) Send(e Event) error {
+	switch {
+	case e.Key == Mouse && (e.Flag == MoveTo || e.Flag == MoveBy || e.Flag == Scroll):
+	case e.Key != Mouse && (e.Flag == KeyDown || e.Flag == KeyUp):
+	default:
+		return InvalidFlag
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.transcript = append(s.transcript, Record{Event: e, Time: time.Now()})
+	switch {
+	case e.Key == Mouse && e.Flag == MoveTo:
+		s.x, s.y = e.X, e.Y
+	case e.Key == Mouse && e.Flag == MoveBy:
+		s.x, s.y = s.x+e.X, s.y+e.Y
+	}
+	if s.Echo && !s.unhooked {
+		e.Injected = true
+		s.queue = append(s.queue, e)
+	}
+	s.cond.Broadcast()
+	return nil
+}
+
+// Block marks key as blocked, there is nothing to consume it from.
+func (s *Sim) Block(key int) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.blocked == nil {
+		s.blocked = make(map[int]int)
+	}
+	s.blocked[key]++
+	return nil
+}
+
+func (s *Sim) Unblock(key int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.blocked == nil {
+		return
+	}
+	s.blocked[key]--
+	if s.blocked[key] <= 0 {
+		delete(s.blocked, key)
+	}
+}
+
+// SendUnicode records r to be returned by Typed.
+func (s *Sim) SendUnicode(r rune) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.typed = append(s.typed, r)
+	return nil
+}
+
+// Typed returns the characters sent by SendUnicode so far.
+func (s *Sim) Typed() string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return string(s.typed)
+}
+
+// Cursor returns where the cursor was moved to, starting from 0, 0.
+func (s *Sim) Cursor() (x, y int, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.x, s.y, nil
+}
+
+// Blocked reports whether key is blocked.
+func (s *Sim) Blocked(key int) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.blocked[key] > 0
+}
+
+func (s *Sim) SetGlobalHook() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for !s.unhooked {
+		s.cond.Wait()
+	}
+	return nil
+}
+
+func (s *Sim) Unhook() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.unhooked = true
+	s.queue = nil
+	s.cond.Broadcast()
+}
+
+func (s *Sim) GetInput() Event {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for len(s.queue) == 0 && !s.unhooked {
+		s.cond.Wait()
+	}
+	if len(s.queue) == 0 {
+		return Event{}
+	}
+
+	e := s.queue[0]
+	s.queue = s.queue[1:]
+	return e
+}
@@ -0,0 +1,207 @@
+package sys
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// inputsCapacity is how many inputs are buffered between the
+// capturing goroutines and GetInput.
+const inputsCapacity = 256
+
+var (
+	// ErrNoDevice is returned when no input device matches the EvdevConfig.
+	ErrNoDevice = errors.New("no matching input device")
+
+	// ErrNotGrabbed is returned by Block when the devices are not grabbed,
+	// since their inputs reach other programs regardless.
+	ErrNotGrabbed = fmt.Errorf("%w without EvdevConfig.Grab", ErrBlockUnsupported)
+)
+
+func init() {
+	Register("linux", func() (Backend, error) {
+		return OpenLinux(EvdevConfig{})
+	})
+	setDefault("linux")
+}
+
+// OpenLinux opens a backend that sends through a uinput virtual device
+// and captures from the evdev devices selected by cfg.
+func OpenLinux(cfg EvdevConfig) (Backend, error) {
+	devs, err := cfg.devices()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := openUinput(uinputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	lb := &linuxBackend{
+		out:      out,
+		grab:     cfg.Grab,
+		inputs:   make(chan Event, inputsCapacity),
+		blocked:  make(map[uint16]int),
+		unhooked: make(chan struct{}),
+	}
+	for _, d := range devs {
+		dev, err := openEvdev(d.Path, cfg.Grab && !d.Absolute)
+		if err != nil {
+			lb.closeDevices()
+			out.Close()
+			return nil, err
+		}
+		lb.devices = append(lb.devices, dev)
+	}
+	return lb, nil
+}
+
+// linuxBackend sends through a uinput virtual device and
+// captures from evdev devices.
+type linuxBackend struct {
+	dropped  uint64 // sent inputs not reported, accessed atomically
+	out      *uinputDevice
+	devices  []*evdevDevice
+	grab     bool
+	inputs   chan Event
+	unhooked chan struct{}
+	once     sync.Once
+
+	blocked    map[uint16]int // counts of Block by Linux code
+	blockedMtx sync.Mutex
+}
+
+// Send also reports e as an injected input. The virtual device is not
+// captured, so this is how its inputs are observed.
+func (lb *linuxBackend) Send(e Event) error {
+	err := lb.out.send(e)
+	if err != nil {
+		return err
+	}
+
+	// Motion is not reported, same as for the devices.
+	if e.Key == Mouse && e.Flag != Scroll {
+		return nil
+	}
+
+	// Send may be called while nothing takes inputs, such as when tearing
+	// down, so inputs are dropped rather than waited on when full.
+	e.Injected = true
+	select {
+	case lb.inputs <- e:
+	case <-lb.unhooked:
+	default:
+		atomic.AddUint64(&lb.dropped, 1)
+	}
+	return nil
+}
+
+func (lb *linuxBackend) SetGlobalHook() error {
+	for _, dev := range lb.devices {
+		go lb.capture(dev)
+	}
+	<-lb.unhooked
+	lb.closeDevices()
+	return nil
+}
+
+// capture reads dev until it is closed.
+// Grabbed devices have every report passed through the virtual device,
+// except for the events of blocked keys.
+func (lb *linuxBackend) capture(dev *evdevDevice) {
+	var report []inputEvent
+	for {
+		ie, err := dev.readRaw()
+		if err != nil {
+			return
+		}
+
+		if dev.grabbed {
+			if ie.Type != evKey || !lb.isBlocked(ie.Code) {
+				report = append(report, ie)
+			}
+			if ie.Type == evSyn && ie.Code == synReport {
+				lb.out.writeRaw(report)
+				report = report[:0]
+			}
+		}
+
+		e, ok := toEvent(ie)
+		if !ok {
+			continue
+		}
+		select {
+		case lb.inputs <- e:
+		case <-lb.unhooked:
+			return
+		}
+	}
+}
+
+func (lb *linuxBackend) GetInput() Event {
+	select {
+	case e := <-lb.inputs:
+		return e
+	case <-lb.unhooked:
+		return Event{}
+	}
+}
+
+func (lb *linuxBackend) Unhook() {
+	lb.once.Do(func() {
+		close(lb.unhooked)
+	})
+}
+
+func (lb *linuxBackend) Block(key int) error {
+	if !lb.grab {
+		return ErrNotGrabbed
+	}
+	code, ok := linuxCodes[key]
+	if !ok {
+		return InvalidKey
+	}
+
+	lb.blockedMtx.Lock()
+	defer lb.blockedMtx.Unlock()
+	lb.blocked[code]++
+	return nil
+}
+
+func (lb *linuxBackend) Unblock(key int) {
+	code, ok := linuxCodes[key]
+	if !ok {
+		return
+	}
+
+	lb.blockedMtx.Lock()
+	defer lb.blockedMtx.Unlock()
+	lb.blocked[code]--
+	if lb.blocked[code] <= 0 {
+		delete(lb.blocked, code)
+	}
+}
+
+func (lb *linuxBackend) isBlocked(code uint16) bool {
+	lb.blockedMtx.Lock()
+	defer lb.blockedMtx.Unlock()
+	return lb.blocked[code] > 0
+}
+
+func (lb *linuxBackend) Dropped() uint64 {
+	return atomic.LoadUint64(&lb.dropped)
+}
+
+func (lb *linuxBackend) closeDevices() {
+	for _, dev := range lb.devices {
+		dev.Close()
+	}
+}
+
+// Close destroys the virtual device.
+func (lb *linuxBackend) Close() error {
+	return lb.out.Close()
+}
@@ -1,5 +1,6 @@
 #include "sys_windows.h"
 #include <algorithm>
+#include <array>
 #include <atomic>
 #include <condition_variable>
 #include <cstdlib>
@@ -34,78 +35,290 @@ namespace input
 {
     HHOOK kbhook, mhook;
     std::mutex mtx;
-    input_t value{};
     std::condition_variable cv;
-    bool ready = false;
-    std::atomic<int> refCount{};
+
+    // The hooks and the queue are global, so only one backend may be
+    // open at a time, from openHook until unhook. hooked is set while
+    // the message loop of the hooks runs on thread.
+    bool open = false, hooked = false;
+    DWORD thread = 0;
+
+    // Inputs are queued in a ring buffer until getInput takes them.
+    // Inputs arriving when it is full are dropped and counted.
+    constexpr size_t capacity = 256;
+    input_t queue[capacity]{};
+    size_t head = 0, size = 0;
+    uint64_t dropped = 0;
+
+    void push(input_t value)
+    {
+        {
+            std::lock_guard lk{mtx};
+            if(size == capacity)
+            {
+                dropped++;
+                return;
+            }
+            queue[(head + size) % capacity] = value;
+            size++;
+        }
+        cv.notify_one();
+    }
 } // namespace input
 
+namespace position
+{
+    // Keys typing characters are identified by where they are, as the
+    // virtual keys they are on a US layout, same as on Linux. Windows
+    // assigns them virtual keys by the active layout instead, so they
+    // are told apart by their set 1 scan codes.
+    constexpr size_t capacity = 0x80;
+
+    constexpr std::array<uint16_t, capacity> makeKeys()
+    {
+        std::array<uint16_t, capacity> keys{};
+        auto row = [&](uint16_t first, const char* s) {
+            for(uint16_t i = 0; s[i] != 0; i++)
+                keys[first + i] = uint16_t(s[i]);
+        };
+        row(0x02, "1234567890");
+        row(0x10, "QWERTYUIOP");
+        row(0x1E, "ASDFGHJKL");
+        row(0x2C, "ZXCVBNM");
+        keys[0x0C] = VK_OEM_MINUS;
+        keys[0x0D] = VK_OEM_PLUS;
+        keys[0x1A] = VK_OEM_4;
+        keys[0x1B] = VK_OEM_6;
+        keys[0x27] = VK_OEM_1;
+        keys[0x28] = VK_OEM_7;
+        keys[0x29] = VK_OEM_3;
+        keys[0x2B] = VK_OEM_5;
+        keys[0x33] = VK_OEM_COMMA;
+        keys[0x34] = VK_OEM_PERIOD;
+        keys[0x35] = VK_OEM_2;
+        keys[0x56] = VK_OEM_102;
+        return keys;
+    }
+
+    // keys maps scan codes to keys, 0 for those not identified by position.
+    constexpr auto keys = makeKeys();
+
+    // key returns the key of a keyboard hook input.
+    uint16_t key(const KBDLLHOOKSTRUCT& hs)
+    {
+        if((hs.flags & LLKHF_EXTENDED) == 0 && hs.scanCode < capacity &&
+           keys[hs.scanCode] != 0)
+            return keys[hs.scanCode];
+        return uint16_t(hs.vkCode);
+    }
+} // namespace position
+
+namespace block
+{
+    // Keys as in keys.go, mouse buttons are above the keyboard keys.
+    constexpr uint16_t leftMouse = 256 + 2, rightMouse = 256 + 3,
+                       middleMouse = 256 + 4, backMouse = 256 + 5,
+                       forwardMouse = 256 + 6;
+    constexpr size_t capacity = 512;
+
+    // Physical inputs of keys with a positive count are consumed.
+    std::atomic<int> counts[capacity]{};
+
+    bool blocked(uint16_t key, bool injected)
+    {
+        return !injected && key < capacity && counts[key] > 0;
+    }
+} // namespace block
+
 LRESULT globalKeyboardHook(int n, WPARAM w, LPARAM l)
 {
-    if(w != WM_KEYDOWN && w != WM_KEYUP)
+    // Alt and keys pressed while it is held are system keys,
+    // which are reported the same as the others.
+    WPARAM flag;
+    switch(w)
+    {
+    case WM_KEYDOWN:
+    case WM_SYSKEYDOWN:
+        flag = WM_KEYDOWN;
+        break;
+    case WM_KEYUP:
+    case WM_SYSKEYUP:
+        flag = WM_KEYUP;
+        break;
+    default:
         return CallNextHookEx(nullptr, n, w, l);
+    }
 
     auto& hs = *(PKBDLLHOOKSTRUCT)l;
-    DWORD code = hs.vkCode;
+    uint16_t code = position::key(hs);
 
-    {
-        std::lock_guard lk{input::mtx};
-        input::value = {.key = uint16_t(code), .flag = uint64_t(w)};
-        input::ready = true;
-    }
-    input::cv.notify_one();
+    bool injected = (hs.flags & LLKHF_INJECTED) != 0;
 
+    input::push({.key = code, .flag = uint64_t(flag), .injected = injected});
+
+    if(block::blocked(code, injected))
+        return 1;
     return CallNextHookEx(nullptr, n, w, l);
 }
 
 LRESULT globalMouseHook(int n, WPARAM w, LPARAM l)
 {
+    auto& hs = *(PMSLLHOOKSTRUCT)l;
+    bool injected = (hs.flags & LLMHF_INJECTED) != 0;
+
+    // key is only set for blocking, the Go side tells the buttons apart
+    // by flag, and the X buttons by input_t::key being XBUTTON1 or 2.
     uint64_t flag = 0;
+    uint16_t key = 0, xbutton = 0;
+    int32_t delta = 0;
     switch(w)
     {
     case WM_LBUTTONDOWN:
     case WM_LBUTTONUP:
+        flag = uint64_t(w);
+        key = block::leftMouse;
+        break;
     case WM_RBUTTONDOWN:
-    case WM_RBUTTONUP: flag = uint64_t(w);
-    }
-
+    case WM_RBUTTONUP:
+        flag = uint64_t(w);
+        key = block::rightMouse;
+        break;
+    case WM_MBUTTONDOWN:
+    case WM_MBUTTONUP:
+        flag = uint64_t(w);
+        key = block::middleMouse;
+        break;
+    case WM_XBUTTONDOWN:
+    case WM_XBUTTONUP:
+        flag = uint64_t(w);
+        xbutton = HIWORD(hs.mouseData);
+        key = xbutton == XBUTTON1 ? block::backMouse : block::forwardMouse;
+        break;
+    case WM_MOUSEWHEEL:
     {
-        std::lock_guard lk{input::mtx};
-        input::value = {.key = 0, .flag = uint64_t(w)};
-        input::ready = true;
+        // High resolution wheels turn by less than a notch at a time,
+        // which add up until they make whole notches. Hooks run on the
+        // thread of setGlobalHook, so the remainder needs no lock.
+        static int32_t remainder = 0;
+        remainder += int16_t(HIWORD(hs.mouseData));
+        delta = remainder / WHEEL_DELTA;
+        remainder %= WHEEL_DELTA;
+        if(delta != 0)
+            flag = uint64_t(w);
+        break;
+    }
     }
-    input::cv.notify_one();
 
+    // Motion is not queued, it would crowd out the buttons.
+    if(flag != 0)
+        input::push({.key = xbutton,
+                     .flag = flag,
+                     .injected = injected,
+                     .x = hs.pt.x,
+                     .y = hs.pt.y,
+                     .delta = delta});
+
+    if(flag != 0 && block::blocked(key, injected))
+        return 1;
     return CallNextHookEx(nullptr, n, w, l);
 }
 
+int openHook()
+{
+    std::lock_guard lk{input::mtx};
+    if(input::open)
+        return 0;
+    input::open = true;
+    input::head = input::size = 0;
+    input::dropped = 0;
+    return 1;
+}
+
 void setGlobalHook()
 {
+    // The thread needs a message queue before unhook can post to it.
+    MSG msg;
+    PeekMessage(&msg, nullptr, WM_USER, WM_USER, PM_NOREMOVE);
+    {
+        std::lock_guard lk{input::mtx};
+        if(!input::open || input::hooked)
+            return;
+        input::hooked = true;
+        input::thread = GetCurrentThreadId();
+    }
+
     input::kbhook =
         SetWindowsHookEx(WH_KEYBOARD_LL, globalKeyboardHook, nullptr, 0);
     input::mhook = SetWindowsHookEx(WH_MOUSE_LL, globalMouseHook, nullptr, 0);
-    input::refCount++;
-    MSG msg;
-    while(input::refCount > 0 && !GetMessage(&msg, nullptr, 0, 0))
+    while(GetMessage(&msg, nullptr, 0, 0) > 0)
     {
         TranslateMessage(&msg);
         DispatchMessage(&msg);
     }
+    UnhookWindowsHookEx(input::kbhook);
+    UnhookWindowsHookEx(input::mhook);
 }
 
 void unhook()
 {
-    UnhookWindowsHookEx(input::kbhook);
-    UnhookWindowsHookEx(input::mhook);
-    input::refCount--;
+    {
+        std::lock_guard lk{input::mtx};
+        if(input::hooked)
+            PostThreadMessage(input::thread, WM_QUIT, 0, 0);
+        input::open = input::hooked = false;
+        input::head = input::size = 0;
+    }
+    input::cv.notify_all();
 }
 
 input_t getInput()
 {
     std::unique_lock lk{input::mtx};
-    input::cv.wait(lk, [] { return input::ready || input::refCount == 0; });
-    input::ready = false;
-    auto tmp = input::value;
-    input::value = {};
+    input::cv.wait(lk, [] { return input::size > 0 || !input::hooked; });
+    if(input::size == 0)
+        return {};
+
+    auto tmp = input::queue[input::head];
+    input::head = (input::head + 1) % input::capacity;
+    input::size--;
     return tmp;
+}
+
+uint16_t scanCode(uint16_t key)
+{
+    for(size_t i = 0; i < position::capacity; i++)
+        if(position::keys[i] == key)
+            return uint16_t(i);
+    return 0;
+}
+
+void blockKey(uint16_t key)
+{
+    if(key < block::capacity)
+        block::counts[key]++;
+}
+
+void unblockKey(uint16_t key)
+{
+    if(key < block::capacity)
+        block::counts[key]--;
+}
+
+void sendUnicode(uint16_t unit)
+{
+    INPUT inputs[2]{};
+    for(auto& in : inputs)
+    {
+        in.type = INPUT_KEYBOARD;
+        in.ki.wScan = unit;
+        in.ki.dwFlags = KEYEVENTF_UNICODE;
+    }
+    inputs[1].ki.dwFlags |= KEYEVENTF_KEYUP;
+    SendInput(2, inputs, sizeof(INPUT));
+}
+
+uint64_t droppedInputs()
+{
+    std::lock_guard lk{input::mtx};
+    return input::dropped;
 }
\ No newline at end of file
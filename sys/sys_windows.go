@@ -7,82 +7,30 @@ package sys
 import "C"
 import (
 	"errors"
+	"sync"
+	"unicode/utf16"
 	"unsafe"
 )
 
-const (
-	// These constants are mapped to their corresponding C constants internally.
-	// The constant for the messages and event functions are _not_ the same,
-	// so some mapping has to happen regardless.
-	KeyDown = iota + 1
-	KeyUp
-
-	// Mouse clicks are represented as keys above the keyboard key range.
-	LeftMouse = int(iota + 256)
-	RightMouse
-)
-
-const (
-	F1  = int(C.VK_F1)
-	F2  = int(C.VK_F2)
-	F3  = int(C.VK_F3)
-	F4  = int(C.VK_F4)
-	F5  = int(C.VK_F5)
-	F6  = int(C.VK_F6)
-	F7  = int(C.VK_F7)
-	F8  = int(C.VK_F8)
-	F9  = int(C.VK_F9)
-	F10 = int(C.VK_F10)
-	F11 = int(C.VK_F11)
-	F12 = int(C.VK_F12)
-	F13 = int(C.VK_F13)
-	F14 = int(C.VK_F14)
-	F15 = int(C.VK_F15)
-	F16 = int(C.VK_F16)
-	F17 = int(C.VK_F17)
-	F18 = int(C.VK_F18)
-	F19 = int(C.VK_F19)
-	F20 = int(C.VK_F20)
-	F21 = int(C.VK_F21)
-	F22 = int(C.VK_F22)
-	F23 = int(C.VK_F23)
-	F24 = int(C.VK_F24)
-
-	Alt        = int(C.VK_MENU)
-	Ctrl       = int(C.VK_CONTROL)
-	LeftCtrl   = int(C.VK_LCONTROL)
-	RightCtrl  = int(C.VK_RCONTROL)
-	Shift      = int(C.VK_SHIFT)
-	LeftShift  = int(C.VK_LSHIFT)
-	RightShift = int(C.VK_RSHIFT)
-	Enter      = int(C.VK_RETURN)
-	Esc        = int(C.VK_ESCAPE)
-	Space      = int(C.VK_SPACE)
-	Left       = int(C.VK_LEFT)
-	Up         = int(C.VK_UP)
-	Right      = int(C.VK_RIGHT)
-	Down       = int(C.VK_DOWN)
-	End        = int(C.VK_END)
-	Home       = int(C.VK_HOME)
-	Delete     = int(C.VK_DELETE)
-
-	Num0 = int(C.VK_NUMPAD0)
-	Num1 = int(C.VK_NUMPAD1)
-	Num2 = int(C.VK_NUMPAD2)
-	Num3 = int(C.VK_NUMPAD3)
-	Num4 = int(C.VK_NUMPAD4)
-	Num5 = int(C.VK_NUMPAD5)
-	Num6 = int(C.VK_NUMPAD6)
-	Num7 = int(C.VK_NUMPAD7)
-	Num8 = int(C.VK_NUMPAD8)
-	Num9 = int(C.VK_NUMPAD9)
-)
+func init() {
+	Register("windows", func() (Backend, error) {
+		if C.openHook() == 0 {
+			return nil, ErrBackendInUse
+		}
+		return &windowsBackend{}, nil
+	})
+	setDefault("windows")
+}
 
-var (
-	InvalidFlag = errors.New("invalid flag")
-)
+// windowsBackend uses low level hooks and keybd_event/mouse_event.
+// The hook state lives on the C side, so only one may be open at a time,
+// and it is closed by Unhook.
+type windowsBackend struct {
+	once sync.Once
+}
 
-func Send(k int, flag uint64) error {
+func (windowsBackend) Send(e Event) error {
+	k, flag := e.Key, e.Flag
 	if k < 256 {
 		var arg C.DWORD
 		switch flag {
@@ -93,11 +41,26 @@ func Send(k int, flag uint64) error {
 		default:
 			return InvalidFlag
 		}
+		// Keys typing characters are sent by where they are,
+		// see position::keys in sys_windows.cpp.
+		if sc := C.scanCode(C.uint16_t(k)); sc != 0 {
+			C.keybd_event(0, C.BYTE(sc), arg|C.KEYEVENTF_SCANCODE, 0)
+			return nil
+		}
+		// Without the extended flag, the right keys are taken as the left
+		// ones, and right alt would not act as AltGr.
+		if k == RightAlt || k == RightCtrl {
+			arg |= C.KEYEVENTF_EXTENDEDKEY
+		}
 		C.keybd_event(C.BYTE(k), 0, arg, 0)
 		return nil
 	}
 
-	var arg C.DWORD
+	if k == Mouse {
+		return sendMouse(e)
+	}
+
+	var arg, data C.DWORD
 	switch {
 	case k == LeftMouse && flag == KeyDown:
 		arg = C.MOUSEEVENTF_LEFTDOWN
@@ -107,28 +70,56 @@ func Send(k int, flag uint64) error {
 		arg = C.MOUSEEVENTF_RIGHTDOWN
 	case k == RightMouse && flag == KeyUp:
 		arg = C.MOUSEEVENTF_RIGHTUP
+	case k == MiddleMouse && flag == KeyDown:
+		arg = C.MOUSEEVENTF_MIDDLEDOWN
+	case k == MiddleMouse && flag == KeyUp:
+		arg = C.MOUSEEVENTF_MIDDLEUP
+	case (k == BackMouse || k == ForwardMouse) && flag == KeyDown:
+		arg, data = C.MOUSEEVENTF_XDOWN, xbutton(k)
+	case (k == BackMouse || k == ForwardMouse) && flag == KeyUp:
+		arg, data = C.MOUSEEVENTF_XUP, xbutton(k)
 	default:
 		return InvalidFlag
 	}
-	C.mouse_event(arg, 0, 0, 0, 0)
+	C.mouse_event(arg, 0, 0, data, 0)
 	return nil
 }
 
-func GetClipboardText() string {
-	cstr := C.getClipboardText()
-	defer C.free(unsafe.Pointer(cstr))
-	return C.GoString(cstr)
+// sendMouse moves the cursor or turns the wheel as specified by e.Flag.
+func sendMouse(e Event) error {
+	switch e.Flag {
+	case MoveTo:
+		C.SetCursorPos(C.int(e.X), C.int(e.Y))
+	case MoveBy:
+		// Relative motion is subject to pointer acceleration.
+		C.mouse_event(C.MOUSEEVENTF_MOVE, C.DWORD(int32(e.X)), C.DWORD(int32(e.Y)), 0, 0)
+	case Scroll:
+		C.mouse_event(C.MOUSEEVENTF_WHEEL, 0, 0, C.DWORD(int32(e.Delta*C.WHEEL_DELTA)), 0)
+	default:
+		return InvalidFlag
+	}
+	return nil
 }
 
-func SetGlobalHook() {
+func xbutton(k int) C.DWORD {
+	if k == BackMouse {
+		return C.XBUTTON1
+	}
+	return C.XBUTTON2
+}
+
+func (windowsBackend) SetGlobalHook() error {
 	C.setGlobalHook()
+	return nil
 }
 
-func Unhook() {
-	C.unhook()
+func (wb *windowsBackend) Unhook() {
+	wb.once.Do(func() {
+		C.unhook()
+	})
 }
 
-func GetInput() (int, uint64) {
+func (windowsBackend) GetInput() Event {
 	input := C.getInput()
 	var key int
 	var flag uint64
@@ -151,9 +142,78 @@ func GetInput() (int, uint64) {
 	case C.WM_RBUTTONUP:
 		key = RightMouse
 		flag = KeyUp
+	case C.WM_MBUTTONDOWN:
+		key = MiddleMouse
+		flag = KeyDown
+	case C.WM_MBUTTONUP:
+		key = MiddleMouse
+		flag = KeyUp
+	case C.WM_XBUTTONDOWN, C.WM_XBUTTONUP:
+		key = ForwardMouse
+		if input.key == C.XBUTTON1 {
+			key = BackMouse
+		}
+		flag = KeyDown
+		if input.flag == C.WM_XBUTTONUP {
+			flag = KeyUp
+		}
+	case C.WM_MOUSEWHEEL:
+		key = Mouse
+		flag = Scroll
 	default:
 		key = int(input.key)
 		flag = uint64(input.flag)
 	}
-	return key, uint64(flag)
+	return Event{
+		Key:      key,
+		Flag:     flag,
+		X:        int(input.x),
+		Y:        int(input.y),
+		Delta:    int(input.delta),
+		Injected: input.injected != 0,
+	}
+}
+
+// blockCapacity is block::capacity in sys_windows.cpp.
+const blockCapacity = 512
+
+func (windowsBackend) Block(key int) error {
+	if key < 0 || key >= blockCapacity {
+		return InvalidKey
+	}
+	C.blockKey(C.uint16_t(key))
+	return nil
+}
+
+func (windowsBackend) Unblock(key int) {
+	if key >= 0 && key < blockCapacity {
+		C.unblockKey(C.uint16_t(key))
+	}
+}
+
+func (windowsBackend) Cursor() (x, y int, err error) {
+	var pt C.POINT
+	if C.GetCursorPos(&pt) == 0 {
+		return 0, 0, errors.New("cannot get cursor position")
+	}
+	return int(pt.x), int(pt.y), nil
+}
+
+// SendUnicode sends r as UTF-16 code units, which the receiving
+// program combines.
+func (windowsBackend) SendUnicode(r rune) error {
+	for _, u := range utf16.Encode([]rune{r}) {
+		C.sendUnicode(C.uint16_t(u))
+	}
+	return nil
+}
+
+func (windowsBackend) Dropped() uint64 {
+	return uint64(C.droppedInputs())
+}
+
+func GetClipboardText() string {
+	cstr := C.getClipboardText()
+	defer C.free(unsafe.Pointer(cstr))
+	return C.GoString(cstr)
 }
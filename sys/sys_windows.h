@@ -11,6 +11,7 @@ extern "C"
 
     const char* getClipboardText();
     LRESULT globalKeyboardHook(int n, WPARAM w, LPARAM l);
+    int openHook();
     void setGlobalHook();
     void unhook();
 
@@ -18,9 +19,17 @@ extern "C"
     {
         uint16_t key;
         uint64_t flag;
+        uint8_t injected;
+        int32_t x, y;
+        int32_t delta;
     };
 
     struct input_t getInput();
+    uint64_t droppedInputs();
+    void blockKey(uint16_t key);
+    void unblockKey(uint16_t key);
+    void sendUnicode(uint16_t unit);
+    uint16_t scanCode(uint16_t key);
 
 #ifdef __cplusplus
 }
@@ -0,0 +1,117 @@
+package sys
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	uinputPath = "/dev/uinput"
+	uinputName = "autokey virtual input"
+)
+
+// ioctl requests from linux/uinput.h.
+const (
+	uiDevCreate  = 0x5501
+	uiDevDestroy = 0x5502
+	uiDevSetup   = 0x405c5503
+	uiSetEvBit   = 0x40045564
+	uiSetKeyBit  = 0x40045565
+	uiSetRelBit  = 0x40045566
+	uiSetMscBit  = 0x40045568
+)
+
+const busVirtual = 0x06
+
+// uinputSetup mirrors struct uinput_setup.
+type uinputSetup struct {
+	Bustype      uint16
+	Vendor       uint16
+	Product      uint16
+	Version      uint16
+	Name         [80]byte
+	FFEffectsMax uint32
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// uinputDevice is a virtual keyboard and mouse created through uinput.
+type uinputDevice struct {
+	f *os.File
+	eventWriter
+}
+
+// openUinput creates a virtual device announcing every keyboard key,
+// the mouse buttons, relative motion and both wheels.
+// The kernel drops codes a device did not announce, so this covers what
+// grabbed keyboards and mice report for it to pass through.
+func openUinput(path string) (*uinputDevice, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	fd := f.Fd()
+	err = setupUinput(fd)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &uinputDevice{f: f, eventWriter: eventWriter{w: f}}, nil
+}
+
+func setupUinput(fd uintptr) error {
+	for _, ev := range []uintptr{evKey, evRel, evMsc} {
+		if err := ioctl(fd, uiSetEvBit, ev); err != nil {
+			return err
+		}
+	}
+	for code := uintptr(1); code <= keyMax; code++ {
+		if err := ioctl(fd, uiSetKeyBit, code); err != nil {
+			return err
+		}
+	}
+	for code := uintptr(btnLeft); code <= btnTask; code++ {
+		if err := ioctl(fd, uiSetKeyBit, code); err != nil {
+			return err
+		}
+	}
+	for code := uintptr(keyOk); code <= keyExtMax; code++ {
+		if err := ioctl(fd, uiSetKeyBit, code); err != nil {
+			return err
+		}
+	}
+	for _, rel := range []uintptr{relX, relY, relHWheel, relWheel, relWheelHiRes, relHWheelHiRes} {
+		if err := ioctl(fd, uiSetRelBit, rel); err != nil {
+			return err
+		}
+	}
+	if err := ioctl(fd, uiSetMscBit, mscScan); err != nil {
+		return err
+	}
+
+	setup := uinputSetup{
+		Bustype: busVirtual,
+		Vendor:  0x1,
+		Product: 0x1,
+		Version: 1,
+	}
+	copy(setup.Name[:], uinputName)
+	if err := ioctl(fd, uiDevSetup, uintptr(unsafe.Pointer(&setup))); err != nil {
+		return err
+	}
+	return ioctl(fd, uiDevCreate, 0)
+}
+
+func (d *uinputDevice) Close() error {
+	ioctl(d.f.Fd(), uiDevDestroy, 0)
+	return d.f.Close()
+}
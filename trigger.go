@@ -0,0 +1,535 @@
+package autokey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// inputsBuffer is how many inputs are buffered for triggers that
+// listen to every input, so that bursts of keys are not dropped.
+const inputsBuffer = 16
+
+// trigger installs listeners sending to ch until ctx is done,
+// after which they uninstall themselves.
+// Sends are dropped if ch is full, so ch should be buffered.
+// Injected inputs are ignored unless told otherwise,
+// so that actions do not trigger themselves.
+type trigger func(ctx context.Context, ch chan<- struct{})
+
+// fire sends on ch unless it is not ready.
+func fire(ch chan<- struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// withSides adds the sided keys of generic modifiers in inputs.
+func withSides(inputs []Input) []Input {
+	ret := inputs
+	for _, v := range inputs {
+		for _, k := range sides[v.Key] {
+			ret = append(ret, Input{Key: k, Flag: v.Flag})
+		}
+	}
+	return ret
+}
+
+// inputTrigger returns a trigger listening on e for any of inputs
+// while all of mods are held down.
+// The modifiers are followed along with inputs, so that they are
+// checked as they were when the input came, not when it is received.
+func inputTrigger(e *Engine, inputs []Input, mods []int) trigger {
+	var modKeys []int // mods and their sides
+	for _, k := range mods {
+		modKeys = append(modKeys, k)
+		modKeys = append(modKeys, sides[k]...)
+	}
+	triggers := make(map[uint64]bool)
+	for _, v := range inputs {
+		triggers[v.asMapKey()] = true
+	}
+
+	return func(ctx context.Context, ch chan<- struct{}) {
+		listened := inputs
+		for _, k := range modKeys {
+			listened = append(listened[:len(listened):len(listened)], Input{Key: k, Flag: KeyDown}, Input{Key: k, Flag: KeyUp})
+		}
+		in := make(chan Input, inputsBuffer)
+		cancel := e.NotifyOn(in, listened...)
+
+		// isPressed takes a generic modifier as down by its sides,
+		// which are followed on their own.
+		down := make(map[int]bool)
+		for _, k := range modKeys {
+			down[k] = len(sides[k]) == 0 && e.isPressed(k)
+		}
+		allDown := func() bool {
+			for _, k := range mods {
+				if down[k] {
+					continue
+				}
+				side := false
+				for _, s := range sides[k] {
+					side = side || down[s]
+				}
+				if !side {
+					return false
+				}
+			}
+			return true
+		}
+
+		go func() {
+			defer cancel()
+			for {
+				var input Input
+				select {
+				case input = <-in:
+				case <-ctx.Done():
+					return
+				}
+				if input.Injected {
+					continue
+				}
+
+				if _, ok := down[input.Key]; ok {
+					down[input.Key] = input.Flag == KeyDown
+				}
+				if triggers[input.asMapKey()] && allDown() {
+					fire(ch)
+				}
+			}
+		}()
+	}
+}
+
+// parseChord parses s as keys joined by "+", such as "ctrl+shift+a".
+// It returns the inputs of the last key, which trigger the chord,
+// and the modifiers that must be held down at the time.
+// Generic modifiers trigger by either of their sides.
+func parseChord(s string) ([]Input, []int, error) {
+	parts := strings.Split(s, "+")
+	var mods []int
+	for _, v := range parts[:len(parts)-1] {
+		input, ok := inputMap[strings.ToLower(strings.TrimSpace(v))]
+		if !ok || input.Flag != 0 {
+			return nil, nil, fmt.Errorf("cannot parse %q as chord modifier", v)
+		}
+		mods = append(mods, input.Key)
+	}
+
+	inputs, err := parseInput(strings.TrimSpace(parts[len(parts)-1]), KeyDown)
+	if err != nil {
+		return nil, nil, err
+	}
+	return withSides(inputs), mods, nil
+}
+
+// sequenceTrigger returns a trigger listening on e for seq pressed in
+// order, with the first and last press no more than within apart.
+// Each element of seq matches any of its inputs. Pressing any other key
+// restarts the sequence. No limit applies if within is 0.
+func sequenceTrigger(e *Engine, seq [][]Input, within time.Duration, injected bool) trigger {
+	matches := func(input Input, step []Input) bool {
+		for _, v := range step {
+			if v.asMapKey() == input.asMapKey() {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(ctx context.Context, ch chan<- struct{}) {
+		in := make(chan Input, inputsBuffer)
+		cancel := e.Notify(in)
+		go func() {
+			defer cancel()
+			down := make(map[int]bool) // to skip key repeats
+			next := 0
+			var start time.Time
+			for {
+				var input Input
+				select {
+				case input = <-in:
+				case <-ctx.Done():
+					return
+				}
+				if input.Injected && !injected {
+					continue
+				}
+
+				switch input.Flag {
+				case KeyDown:
+					if down[input.Key] {
+						continue
+					}
+					down[input.Key] = true
+				case KeyUp:
+					delete(down, input.Key)
+				}
+
+				now := time.Now()
+				if next > 0 && within > 0 && now.Sub(start) > within {
+					next = 0
+				}
+				switch {
+				case matches(input, seq[next]):
+				case input.Flag != KeyDown:
+					continue
+				case matches(input, seq[0]):
+					next = 0
+				default:
+					next = 0
+					continue
+				}
+
+				if next == 0 {
+					start = now
+				}
+				next++
+				if next == len(seq) {
+					next = 0
+					fire(ch)
+				}
+			}
+		}()
+	}
+}
+
+// parseSequence parses m as a sequenceTrigger.
+// The sequence key is a slice of elements accepted by parseInput,
+// within is an optional duration.
+func parseSequence(e *Engine, m map[string]interface{}) (trigger, error) {
+	val, ok := m["sequence"].([]interface{})
+	if !ok || len(val) == 0 {
+		return nil, errors.New("sequence must be a non-empty sequence")
+	}
+	var seq [][]Input
+	for _, v := range val {
+		inputs, err := parseInput(v, KeyDown)
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, withSides(inputs))
+	}
+
+	var within time.Duration
+	if v, has := m["within"]; has {
+		dur, err := parseDuration(v)
+		if err != nil {
+			return nil, err
+		}
+		if dur <= 0 {
+			return nil, errors.New("duration has to be positive")
+		}
+		within = dur
+	}
+	injected, err := parseInjected(m)
+	if err != nil {
+		return nil, err
+	}
+	return sequenceTrigger(e, seq, within, injected), nil
+}
+
+// keyMode is when a keyTrigger fires.
+type keyMode int
+
+const (
+	onPress   keyMode = iota // the key goes down, repeats included
+	onRelease                // the key goes up
+	onHold                   // the key stays down for a duration
+	onDouble                 // the key goes down twice within a duration
+)
+
+// keyTrigger returns a trigger listening on e for any of keys
+// to be pressed according to mode and dur.
+func keyTrigger(e *Engine, keys []int, mode keyMode, dur time.Duration, injected bool) trigger {
+	var inputs []Input
+	for _, k := range keys {
+		inputs = append(inputs, Input{Key: k, Flag: KeyDown}, Input{Key: k, Flag: KeyUp})
+	}
+
+	return func(ctx context.Context, ch chan<- struct{}) {
+		in := make(chan Input, inputsBuffer)
+		cancel := e.NotifyOn(in, inputs...)
+		go func() {
+			defer cancel()
+			down := make(map[int]bool) // to tell key repeats apart
+			var lastKey int            // key of the last press for onDouble
+			var last time.Time         // time of the last press for onDouble
+
+			// Each key held down has its own timer for onHold. A timer
+			// that expires as it is stopped is told apart by identity.
+			holds := make(map[int]*time.Timer)
+			expired := make(chan *time.Timer)
+			stopTimer := func(key int) {
+				if timer, ok := holds[key]; ok {
+					timer.Stop()
+					delete(holds, key)
+				}
+			}
+			defer func() {
+				for k := range holds {
+					stopTimer(k)
+				}
+			}()
+
+			for {
+				var input Input
+				select {
+				case input = <-in:
+					if input.Injected && !injected {
+						continue
+					}
+				case timer := <-expired:
+					for k, v := range holds {
+						if v == timer {
+							delete(holds, k)
+							fire(ch)
+						}
+					}
+					continue
+				case <-ctx.Done():
+					return
+				}
+
+				repeat := input.Flag == KeyDown && down[input.Key]
+				if input.Flag == KeyDown {
+					down[input.Key] = true
+				} else {
+					delete(down, input.Key)
+				}
+
+				switch mode {
+				case onPress:
+					if input.Flag == KeyDown {
+						fire(ch)
+					}
+				case onRelease:
+					if input.Flag == KeyUp {
+						fire(ch)
+					}
+				case onHold:
+					if input.Flag == KeyUp {
+						stopTimer(input.Key)
+					} else if !repeat {
+						stopTimer(input.Key)
+						self := make(chan *time.Timer, 1)
+						timer := time.AfterFunc(dur, func() {
+							select {
+							case expired <- <-self:
+							case <-ctx.Done():
+							}
+						})
+						self <- timer
+						holds[input.Key] = timer
+					}
+				case onDouble:
+					if input.Flag != KeyDown || repeat {
+						continue
+					}
+					now := time.Now()
+					if input.Key == lastKey && now.Sub(last) <= dur {
+						lastKey = 0
+						fire(ch)
+						continue
+					}
+					lastKey, last = input.Key, now
+				}
+			}
+		}()
+	}
+}
+
+// parseWhen parses val as the mode of a keyTrigger.
+// Accepts "press", "release", "hold <duration>" and "double <duration>".
+func parseWhen(val interface{}) (keyMode, time.Duration, error) {
+	s, ok := val.(string)
+	if !ok {
+		return 0, 0, errors.New("cannot parse as when")
+	}
+
+	fields := strings.Fields(strings.ToLower(s))
+	switch {
+	case len(fields) == 1 && fields[0] == "press":
+		return onPress, 0, nil
+	case len(fields) == 1 && fields[0] == "release":
+		return onRelease, 0, nil
+	case len(fields) == 2 && (fields[0] == "hold" || fields[0] == "double"):
+		dur, err := parseDuration(fields[1])
+		if err != nil {
+			return 0, 0, err
+		}
+		if dur <= 0 {
+			return 0, 0, errors.New("duration has to be positive")
+		}
+		if fields[0] == "hold" {
+			return onHold, dur, nil
+		}
+		return onDouble, dur, nil
+	}
+	return 0, 0, errors.New("when must be press, release, hold <duration> or double <duration>")
+}
+
+// parseKey parses m as a keyTrigger.
+// The key key is accepted by parseInput without up or down,
+// when is accepted by parseWhen and defaults to press.
+func parseKey(e *Engine, m map[string]interface{}) (trigger, error) {
+	inputs, err := parseInput(m["key"], 0)
+	if err != nil {
+		return nil, err
+	}
+	var keys []int
+	for _, v := range withSides(inputs) {
+		if v.Flag != 0 {
+			return nil, errors.New("key cannot be suffixed with up or down")
+		}
+		keys = append(keys, v.Key)
+	}
+
+	mode, dur := onPress, time.Duration(0)
+	if v, has := m["when"]; has {
+		mode, dur, err = parseWhen(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	injected, err := parseInjected(m)
+	if err != nil {
+		return nil, err
+	}
+	return keyTrigger(e, keys, mode, dur, injected), nil
+}
+
+// parseInjected parses the optional injected key of m, which makes a
+// trigger observe injected inputs as well.
+func parseInjected(m map[string]interface{}) (bool, error) {
+	val, has := m["injected"]
+	if !has {
+		return false, nil
+	}
+	injected, ok := val.(bool)
+	if !ok {
+		return false, errors.New("injected must be a bool")
+	}
+	return injected, nil
+}
+
+// parseTriggerMap parses a mapping in a trigger, its keys select the kind.
+func parseTriggerMap(e *Engine, m map[string]interface{}) (trigger, error) {
+	var parse func(*Engine, map[string]interface{}) (trigger, error)
+	var keys []string
+	switch {
+	case m["sequence"] != nil:
+		parse = parseSequence
+		keys = []string{"sequence", "within", "injected"}
+	case m["key"] != nil:
+		parse = parseKey
+		keys = []string{"key", "when", "injected"}
+	default:
+		return nil, errors.New("cannot parse as trigger")
+	}
+
+	for k := range m {
+		if !hasString(keys, k) {
+			return nil, fmt.Errorf("%v: %w", k, ErrInvalidKey)
+		}
+	}
+	return parse(e, m)
+}
+
+// hasString reports whether s is in strs.
+func hasString(strs []string, s string) bool {
+	for _, v := range strs {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// triggerKeys returns the keys of the trigger val, for them to be blocked.
+// Only keys and key triggers can be blocked, chords and sequences
+// cannot since they are made of keys that are also pressed alone.
+func triggerKeys(val interface{}) ([]int, error) {
+	switch yml := val.(type) {
+	case string:
+		if strings.Contains(yml, "+") {
+			return nil, errors.New("chords cannot be blocked")
+		}
+	case map[string]interface{}:
+		if yml["key"] == nil {
+			return nil, errors.New("only keys can be blocked")
+		}
+		val = yml["key"]
+	case []interface{}:
+		var keys []int
+		for _, v := range yml {
+			sub, err := triggerKeys(v)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, sub...)
+		}
+		return keys, nil
+	}
+
+	inputs, err := parseInput(val, KeyDown)
+	if err != nil {
+		return nil, err
+	}
+	var keys []int
+	for _, v := range inputs {
+		keys = append(keys, v.Key)
+	}
+	return keys, nil
+}
+
+// parseTrigger parses val as a trigger listening on e.
+// Accepts ints, strings, chords, mappings or slices of them.
+func parseTrigger(e *Engine, val interface{}) (trigger, error) {
+	switch yml := val.(type) {
+	case string:
+		if strings.Contains(yml, "+") {
+			inputs, mods, err := parseChord(yml)
+			if err != nil {
+				return nil, err
+			}
+			return inputTrigger(e, inputs, mods), nil
+		}
+
+		inputs, err := parseInput(yml, KeyDown)
+		if err != nil {
+			return nil, err
+		}
+		return inputTrigger(e, inputs, nil), nil
+	case int:
+		inputs, err := parseInput(yml, KeyDown)
+		if err != nil {
+			return nil, err
+		}
+		return inputTrigger(e, inputs, nil), nil
+	case map[string]interface{}:
+		return parseTriggerMap(e, yml)
+	case []interface{}:
+		var installs []trigger
+		for _, v := range yml {
+			install, err := parseTrigger(e, v)
+			if err != nil {
+				return nil, err
+			}
+			installs = append(installs, install)
+		}
+		return func(ctx context.Context, ch chan<- struct{}) {
+			for _, v := range installs {
+				v(ctx, ch)
+			}
+		}, nil
+	}
+
+	return nil, errors.New("cannot parse as trigger")
+}
@@ -0,0 +1,132 @@
+package autokey
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Sinacam/autokey/sys"
+)
+
+// startKeyTrigger installs a keyTrigger for keys on an Engine over a Sim.
+// Fires are buffered so that none are dropped while the test is not
+// receiving.
+func startKeyTrigger(t *testing.T, keys []int, mode keyMode, dur time.Duration) (*sys.Sim, <-chan struct{}) {
+	t.Helper()
+	s := sys.NewSim()
+	e := NewEngine()
+	e.InitBackend(s)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		e.Teardown()
+	})
+
+	ch := make(chan struct{}, 16)
+	keyTrigger(e, keys, mode, dur, false)(ctx, ch)
+	return s, ch
+}
+
+func down(key int) sys.Event { return sys.Event{Key: key, Flag: KeyDown} }
+func up(key int) sys.Event   { return sys.Event{Key: key, Flag: KeyUp} }
+
+// fired reports whether ch receives within timeout.
+func fired(ch <-chan struct{}, timeout time.Duration) bool {
+	select {
+	case <-ch:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestKeyTriggerRelease(t *testing.T) {
+	s, ch := startKeyTrigger(t, []int{F6}, onRelease, 0)
+
+	s.Inject(down(F6), down(F6))
+	if fired(ch, 50*time.Millisecond) {
+		t.Fatal("fired on press")
+	}
+	s.Inject(up(F6))
+	if !fired(ch, time.Second) {
+		t.Fatal("did not fire on release")
+	}
+}
+
+func TestKeyTriggerHold(t *testing.T) {
+	s, ch := startKeyTrigger(t, []int{F6}, onHold, 100*time.Millisecond)
+
+	s.Inject(down(F6))
+	if fired(ch, 50*time.Millisecond) {
+		t.Fatal("fired before the duration")
+	}
+	// Auto-repeats do not restart the duration.
+	s.Inject(down(F6))
+	if !fired(ch, 100*time.Millisecond) {
+		t.Fatal("did not fire after the duration")
+	}
+	if fired(ch, 200*time.Millisecond) {
+		t.Fatal("fired more than once in one hold")
+	}
+}
+
+func TestKeyTriggerHoldCancelled(t *testing.T) {
+	s, ch := startKeyTrigger(t, []int{F6}, onHold, 100*time.Millisecond)
+
+	s.Inject(down(F6))
+	time.Sleep(30 * time.Millisecond)
+	s.Inject(up(F6))
+	if fired(ch, 200*time.Millisecond) {
+		t.Fatal("fired after the key was released early")
+	}
+}
+
+func TestKeyTriggerHoldPerKey(t *testing.T) {
+	s, ch := startKeyTrigger(t, []int{F6, F7}, onHold, 100*time.Millisecond)
+
+	// Tapping F7 while F6 is held does not cancel the hold of F6.
+	s.Inject(down(F6))
+	time.Sleep(30 * time.Millisecond)
+	s.Inject(down(F7), up(F7))
+	if !fired(ch, 150*time.Millisecond) {
+		t.Fatal("did not fire for the key still held")
+	}
+	if fired(ch, 200*time.Millisecond) {
+		t.Fatal("fired for the key released early")
+	}
+}
+
+func TestKeyTriggerDouble(t *testing.T) {
+	s, ch := startKeyTrigger(t, []int{F6}, onDouble, 200*time.Millisecond)
+
+	s.Inject(down(F6), up(F6), down(F6), up(F6))
+	if !fired(ch, time.Second) {
+		t.Fatal("did not fire on a double tap")
+	}
+
+	// The second tap of a double does not start another.
+	s.Inject(down(F6), up(F6))
+	if fired(ch, 100*time.Millisecond) {
+		t.Fatal("fired on the third tap")
+	}
+}
+
+func TestKeyTriggerDoubleExpired(t *testing.T) {
+	s, ch := startKeyTrigger(t, []int{F6}, onDouble, 100*time.Millisecond)
+
+	s.Inject(down(F6), up(F6))
+	time.Sleep(200 * time.Millisecond)
+	s.Inject(down(F6), up(F6))
+	if fired(ch, 50*time.Millisecond) {
+		t.Fatal("fired on taps further apart than the duration")
+	}
+}
+
+func TestKeyTriggerDoubleRepeat(t *testing.T) {
+	s, ch := startKeyTrigger(t, []int{F6}, onDouble, 200*time.Millisecond)
+
+	s.Inject(down(F6), down(F6), down(F6), up(F6))
+	if fired(ch, 100*time.Millisecond) {
+		t.Fatal("fired on auto-repeats of a single press")
+	}
+}